@@ -1,29 +1,84 @@
 package agent
 
 import (
+	"crypto"
 	"crypto/rand"
-	"crypto/rsa"
 	"crypto/x509"
 	"crypto/x509/pkix"
-	"encoding/base64"
+	"encoding/asn1"
+	"encoding/pem"
+	"errors"
+	"fmt"
 )
 
-// CreateCertificateRequest creates CSR in DER encoded in base64.
-func CreateCertificateRequest(certPrivkey *rsa.PrivateKey, commonName string, domain ...string) (string, error) {
-	dnsName := append([]string{commonName}, domain...)
-	csr := &x509.CertificateRequest{
-		SignatureAlgorithm: x509.SHA256WithRSA,
-		PublicKeyAlgorithm: x509.RSA,
-		Subject: pkix.Name{
-			CommonName: commonName,
-		},
-		DNSNames: dnsName,
+// tlsFeatureExtension is the TLS Feature extension requesting the status_request (OCSP Must-Staple) in RFC 7633.
+var tlsFeatureExtension = pkix.Extension{
+	Id:    asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 1, 24},
+	Value: []byte{0x30, 0x03, 0x02, 0x01, 0x05},
+}
+
+// CreateCertificateRequest creates CSR in PEM for the domains signed by the key.
+// The first domain is used as the CommonName if subject does not have one.
+func CreateCertificateRequest(key crypto.Signer, subject pkix.Name, mustStaple bool, domains ...string) ([]byte, error) {
+	if len(domains) == 0 {
+		return nil, errors.New("aaa: no domain for the CSR")
+	}
+
+	if subject.CommonName == "" {
+		subject.CommonName = domains[0]
+	}
+
+	tmpl := &x509.CertificateRequest{
+		Subject:  subject,
+		DNSNames: domains,
+	}
+
+	if mustStaple {
+		tmpl.ExtraExtensions = append(tmpl.ExtraExtensions, tlsFeatureExtension)
+	}
+
+	der, err := x509.CreateCertificateRequest(rand.Reader, tmpl, key)
+	if err != nil {
+		return nil, err
+	}
+
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: der}), nil
+}
+
+// ParseCertificateRequest parses CSR in PEM or DER and verifies its signature.
+func ParseCertificateRequest(blob []byte) (*x509.CertificateRequest, error) {
+	if block, _ := pem.Decode(blob); block != nil {
+		if block.Type != "CERTIFICATE REQUEST" && block.Type != "NEW CERTIFICATE REQUEST" {
+			return nil, fmt.Errorf("aaa: unexpected PEM block '%s' for CSR", block.Type)
+		}
+
+		blob = block.Bytes
 	}
 
-	der, err := x509.CreateCertificateRequest(rand.Reader, csr, certPrivkey)
+	csr, err := x509.ParseCertificateRequest(blob)
 	if err != nil {
-		return "", err
+		return nil, fmt.Errorf("parsing the CSR: %w", err)
+	}
+
+	if err := csr.CheckSignature(); err != nil {
+		return nil, fmt.Errorf("verifying the signature of the CSR: %w", err)
+	}
+
+	return csr, nil
+}
+
+// CertificateRequestDomains returns the domains in the CSR starting with the CommonName.
+func CertificateRequestDomains(csr *x509.CertificateRequest) []string {
+	var domains []string
+	if cn := csr.Subject.CommonName; cn != "" {
+		domains = append(domains, cn)
+	}
+
+	for _, name := range csr.DNSNames {
+		if name != csr.Subject.CommonName {
+			domains = append(domains, name)
+		}
 	}
 
-	return base64.RawURLEncoding.EncodeToString(der), nil
+	return domains
 }
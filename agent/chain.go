@@ -0,0 +1,176 @@
+package agent
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/go-acme/lego/v4/certcrypto"
+)
+
+// maxChainLength limits the number of the issuer certificates fetched via AIA.
+const maxChainLength = 5
+
+// aiaTimeout and maxIssuerSize limit fetching the issuer so that a broken AIA server does not block the sync.
+const (
+	aiaTimeout    = 30 * time.Second
+	maxIssuerSize = 1 << 20
+)
+
+var aiaClient = &http.Client{Timeout: aiaTimeout}
+
+// CertBundle is the leaf certificate with its issuer chain.
+type CertBundle struct {
+	Leaf *x509.Certificate
+
+	// Chain is the intermediate certificates ordered from the issuer of the leaf.
+	Chain []*x509.Certificate
+
+	// PEM is the bundle as stored. It is nil unless the bundle is parsed by ParseCertBundle.
+	PEM []byte
+}
+
+// ParseCertBundle parses the certificates in PEM. The first certificate must be the leaf.
+func ParseCertBundle(blob []byte) (*CertBundle, error) {
+	certs, err := certcrypto.ParsePEMBundle(blob)
+	if err != nil {
+		return nil, fmt.Errorf("parsing the certificate bundle: %w", err)
+	}
+
+	if len(certs) == 0 {
+		return nil, errors.New("aaa: no certificate found in the bundle")
+	}
+
+	return &CertBundle{
+		Leaf:  certs[0],
+		Chain: certs[1:],
+		PEM:   blob,
+	}, nil
+}
+
+// CompleteChain fetches the missing intermediate certificates via Authority Information Access
+// if the bundle does not have the chain. The root certificate is not included.
+func (b *CertBundle) CompleteChain(ctx context.Context) error {
+	if len(b.Chain) > 0 {
+		return nil
+	}
+
+	cert := b.Leaf
+	for range maxChainLength {
+		if len(cert.IssuingCertificateURL) == 0 {
+			break
+		}
+
+		issuer, err := fetchIssuer(ctx, cert.IssuingCertificateURL[0])
+		if err != nil {
+			return fmt.Errorf("fetching the issuer of '%s': %w", cert.Subject, err)
+		}
+
+		if err := cert.CheckSignatureFrom(issuer); err != nil {
+			return fmt.Errorf("verifying the issuer of '%s': %w", cert.Subject, err)
+		}
+
+		// reaching the root
+		if bytes.Equal(issuer.RawSubject, issuer.RawIssuer) {
+			break
+		}
+
+		b.Chain = append(b.Chain, issuer)
+		cert = issuer
+	}
+
+	if len(b.Chain) == 0 {
+		return errors.New("aaa: the certificate does not have the chain and it can't be fetched via AIA")
+	}
+
+	return nil
+}
+
+// VerifyKey ensures that key is the private key for the leaf certificate.
+func (b *CertBundle) VerifyKey(key crypto.PrivateKey) error {
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return errors.New("aaa: unsupported private key")
+	}
+
+	pub, ok := signer.Public().(interface{ Equal(crypto.PublicKey) bool })
+	if !ok || !pub.Equal(b.Leaf.PublicKey) {
+		return errors.New("aaa: the private key does not match the certificate")
+	}
+
+	return nil
+}
+
+// CertPEM returns the bundle as stored, or the leaf certificate in PEM if it is not parsed from PEM.
+func (b *CertBundle) CertPEM() []byte {
+	if len(b.PEM) > 0 {
+		return b.PEM
+	}
+
+	return b.LeafPEM()
+}
+
+// LeafPEM returns the leaf certificate in PEM.
+func (b *CertBundle) LeafPEM() []byte {
+	return encodeCertsPEM(b.Leaf)
+}
+
+// ChainPEM returns the intermediate certificates in PEM.
+func (b *CertBundle) ChainPEM() []byte {
+	return encodeCertsPEM(b.Chain...)
+}
+
+// FullChainPEM returns the leaf and the intermediate certificates in PEM.
+func (b *CertBundle) FullChainPEM() []byte {
+	return append(b.LeafPEM(), b.ChainPEM()...)
+}
+
+func encodeCertsPEM(certs ...*x509.Certificate) []byte {
+	var buf bytes.Buffer
+	for _, cert := range certs {
+		pem.Encode(&buf, &pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw})
+	}
+
+	return buf.Bytes()
+}
+
+func fetchIssuer(ctx context.Context, url string) (*x509.Certificate, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := aiaClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %d from %s", resp.StatusCode, url)
+	}
+
+	blob, err := io.ReadAll(io.LimitReader(resp.Body, maxIssuerSize+1))
+	if err != nil {
+		return nil, err
+	}
+
+	if len(blob) > maxIssuerSize {
+		return nil, fmt.Errorf("the issuer from %s exceeds %d bytes", url, maxIssuerSize)
+	}
+
+	// the issuer may be served in PEM instead of DER
+	if block, _ := pem.Decode(blob); block != nil {
+		blob = block.Bytes
+	}
+
+	return x509.ParseCertificate(blob)
+}
@@ -0,0 +1,140 @@
+package agent
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"testing"
+	"time"
+)
+
+// testCert is the certificate and its private key issued by newTestCert.
+type testCert struct {
+	Cert *x509.Certificate
+	Key  crypto.Signer
+}
+
+// newTestCert issues the certificate for cn by parent. It is self-signed if parent is nil.
+func newTestCert(t *testing.T, cn string, parent *testCert, dnsNames ...string) *testCert {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	serial, err := rand.Int(rand.Reader, big.NewInt(1<<62))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tmpl := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: cn},
+		DNSNames:              dnsNames,
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(24 * time.Hour),
+		BasicConstraintsValid: true,
+		IsCA:                  len(dnsNames) == 0,
+	}
+
+	issuer, signer := tmpl, crypto.Signer(key)
+	if parent != nil {
+		issuer, signer = parent.Cert, parent.Key
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, issuer, key.Public(), signer)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &testCert{Cert: cert, Key: key}
+}
+
+// newTestBundle returns the bundle of the leaf for example.com with the intermediate, and the private key of the leaf.
+func newTestBundle(t *testing.T) (*CertBundle, crypto.Signer) {
+	t.Helper()
+
+	root := newTestCert(t, "Test Root", nil)
+	intermediate := newTestCert(t, "Test Intermediate", root)
+	leaf := newTestCert(t, "example.com", intermediate, "example.com", "www.example.com")
+
+	return &CertBundle{Leaf: leaf.Cert, Chain: []*x509.Certificate{intermediate.Cert}}, leaf.Key
+}
+
+func TestParseCertBundle(t *testing.T) {
+	bundle, _ := newTestBundle(t)
+
+	for _, tc := range []struct {
+		name      string
+		blob      []byte
+		wantChain int
+		wantErr   bool
+	}{
+		{name: "leaf only", blob: bundle.LeafPEM(), wantChain: 0},
+		{name: "full chain", blob: bundle.FullChainPEM(), wantChain: 1},
+		{name: "empty", blob: nil, wantErr: true},
+		{name: "not PEM", blob: []byte("hello"), wantErr: true},
+		{name: "broken certificate", blob: []byte("-----BEGIN CERTIFICATE-----\naGVsbG8=\n-----END CERTIFICATE-----\n"), wantErr: true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := ParseCertBundle(tc.blob)
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("ParseCertBundle() = %v, wantErr %v", err, tc.wantErr)
+			}
+
+			if err != nil {
+				return
+			}
+
+			if !got.Leaf.Equal(bundle.Leaf) {
+				t.Errorf("Leaf = %s, want %s", got.Leaf.Subject, bundle.Leaf.Subject)
+			}
+
+			if len(got.Chain) != tc.wantChain {
+				t.Errorf("len(Chain) = %d, want %d", len(got.Chain), tc.wantChain)
+			}
+		})
+	}
+}
+
+func TestCertBundleVerifyKey(t *testing.T) {
+	bundle, key := newTestBundle(t)
+	other, _ := newTestBundle(t)
+	_, otherKey := newTestBundle(t)
+
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		name    string
+		bundle  *CertBundle
+		key     crypto.PrivateKey
+		wantErr bool
+	}{
+		{name: "matching", bundle: bundle, key: key},
+		{name: "another ECDSA key", bundle: bundle, key: otherKey, wantErr: true},
+		{name: "another certificate", bundle: other, key: key, wantErr: true},
+		{name: "RSA key", bundle: bundle, key: rsaKey, wantErr: true},
+		{name: "not a signer", bundle: bundle, key: "key", wantErr: true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			err := tc.bundle.VerifyKey(tc.key)
+			if (err != nil) != tc.wantErr {
+				t.Errorf("VerifyKey() = %v, wantErr %v", err, tc.wantErr)
+			}
+		})
+	}
+}
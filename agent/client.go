@@ -2,7 +2,12 @@ package agent
 
 import (
 	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"fmt"
 	"os"
+	"time"
 
 	"github.com/go-acme/lego/v4/lego"
 	"github.com/go-acme/lego/v4/registration"
@@ -13,10 +18,16 @@ var DefaultDirectoryURL = "https://acme-staging-v02.api.letsencrypt.org/director
 
 // RegistrationInfo is a data persisted on the storage.
 // A private key for this will be persisted in JWK.
+// The key can't be a crypto.Signer such as KMS keys since lego signs the requests only with
+// *rsa.PrivateKey and *ecdsa.PrivateKey in memory.
 type RegistrationInfo struct {
 	Email        string                 `json:"email"`
 	Registration *registration.Resource `json:"registration"`
 	Key          *jose.JSONWebKey       `json:"key"`
+
+	// DirectoryURL and CreatedAt are recorded on registration. They are empty for the old registrations.
+	DirectoryURL string    `json:"directory_url,omitempty"`
+	CreatedAt    time.Time `json:"created_at,omitzero"`
 }
 
 func (ri *RegistrationInfo) GetEmail() string {
@@ -24,6 +35,10 @@ func (ri *RegistrationInfo) GetEmail() string {
 }
 
 func (ri *RegistrationInfo) GetPrivateKey() crypto.PrivateKey {
+	if ri.Key == nil {
+		return nil
+	}
+
 	return ri.Key.Key
 }
 
@@ -42,6 +57,17 @@ func DirectoryURL() string {
 // NewClient2 initializes the lego ACME client and returns the client.
 // If it fails to initialize the client, it will return an error.
 func NewLegoClient(ri *RegistrationInfo) (*lego.Client, error) {
+	// lego would send the requests without the signature algorithm for the other keys
+	switch key := ri.GetPrivateKey().(type) {
+	case *rsa.PrivateKey:
+	case *ecdsa.PrivateKey:
+		if key.Curve != elliptic.P256() && key.Curve != elliptic.P384() {
+			return nil, fmt.Errorf("aaa: unsupported curve %s for the account key. it must be P-256 or P-384", key.Curve.Params().Name)
+		}
+	default:
+		return nil, fmt.Errorf("aaa: unsupported account key %T. the account key must be RSA or ECDSA private key", ri.GetPrivateKey())
+	}
+
 	config := lego.NewConfig(ri)
 	config.CADirURL = DirectoryURL()
 
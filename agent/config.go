@@ -0,0 +1,147 @@
+package agent
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DomainConfig is a configuration persisted on the storage per domain.
+type DomainConfig struct {
+	Hooks []HookConfig `json:"hooks,omitempty"`
+
+	// ACMTargets are the regions and the accounts that the certificate is imported into.
+	// The default region and account are used if empty.
+	ACMTargets []ACMTarget `json:"acm_targets,omitempty"`
+
+	// Issuance is the options for the order that are kept for the renewals.
+	Issuance IssuanceConfig `json:"issuance,omitzero"`
+}
+
+// IssuanceConfig is the options for the certificate order.
+type IssuanceConfig struct {
+	// PreferredChain is the CommonName of the issuer in the alternate chain to be preferred.
+	PreferredChain string `json:"preferred_chain,omitempty"`
+
+	// Profile is the ACME profile such as shortlived and tlsserver.
+	Profile string `json:"profile,omitempty"`
+
+	MustStaple bool `json:"must_staple,omitempty"`
+
+	// NotAfter is the validity period requested relative to the issuance such as 6d and 144h.
+	NotAfter string `json:"not_after,omitempty"`
+}
+
+// NotAfterTime returns notAfter to be requested for the issuance at now. It returns the zero time if not set.
+func (c IssuanceConfig) NotAfterTime(now time.Time) (time.Time, error) {
+	if c.NotAfter == "" {
+		return time.Time{}, nil
+	}
+
+	d, err := ParseDays(c.NotAfter)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("parsing not_after '%s': %w", c.NotAfter, err)
+	}
+
+	if d <= 0 {
+		return time.Time{}, fmt.Errorf("not_after '%s' must be positive", c.NotAfter)
+	}
+
+	return now.Add(d), nil
+}
+
+// ParseDays parses the duration that also accepts the number of days such as 30d.
+func ParseDays(s string) (time.Duration, error) {
+	if days, ok := strings.CutSuffix(s, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err != nil {
+			return 0, err
+		}
+
+		return time.Duration(n) * 24 * time.Hour, nil
+	}
+
+	return time.ParseDuration(s)
+}
+
+// ACMTarget is a region and an account to import the certificate into ACM.
+type ACMTarget struct {
+	// Region is the default region if empty.
+	Region string `json:"region,omitempty"`
+
+	// RoleARN is assumed to import into another account. The default credentials are used if empty.
+	RoleARN string `json:"role_arn,omitempty"`
+}
+
+// Key returns the key to track the ARN per target in the metadata.
+func (t ACMTarget) Key() string {
+	return t.RoleARN + "@" + t.Region
+}
+
+// IsDefault reports whether the target is the default region and account.
+func (t ACMTarget) IsDefault() bool {
+	return t.Region == "" && t.RoleARN == ""
+}
+
+// Validate validates the configuration.
+func (c *DomainConfig) Validate() error {
+	var errs []error
+	for i := range c.Hooks {
+		if err := c.Hooks[i].Validate(); err != nil {
+			errs = append(errs, fmt.Errorf("hooks[%d]: %w", i, err))
+		}
+	}
+
+	if _, err := c.Issuance.NotAfterTime(time.Now()); err != nil {
+		errs = append(errs, fmt.Errorf("issuance: %w", err))
+	}
+
+	return errors.Join(errs...)
+}
+
+// Hook types
+const (
+	HookTypeExec    = "exec"
+	HookTypeWebhook = "webhook"
+	HookTypeLambda  = "lambda"
+	HookTypeACM     = "acm"
+)
+
+// HookConfig is a hook to be executed after the certificate is issued.
+type HookConfig struct {
+	Type string `json:"type"`
+
+	// Command is executed by sh for exec hook.
+	Command string `json:"command,omitempty"`
+
+	// URL receives the event in POST for webhook hook.
+	URL string `json:"url,omitempty"`
+
+	// FunctionName is invoked with the event for lambda hook.
+	FunctionName string `json:"function_name,omitempty"`
+}
+
+// Validate validates the hook.
+func (h *HookConfig) Validate() error {
+	switch h.Type {
+	case HookTypeExec:
+		if h.Command == "" {
+			return errors.New("command is required for exec hook")
+		}
+	case HookTypeWebhook:
+		if h.URL == "" {
+			return errors.New("url is required for webhook hook")
+		}
+	case HookTypeLambda:
+		if h.FunctionName == "" {
+			return errors.New("function_name is required for lambda hook")
+		}
+	case HookTypeACM:
+	default:
+		return fmt.Errorf("unknown hook type '%s'", h.Type)
+	}
+
+	return nil
+}
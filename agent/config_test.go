@@ -0,0 +1,62 @@
+package agent
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseDays(t *testing.T) {
+	for _, tc := range []struct {
+		in      string
+		want    time.Duration
+		wantErr bool
+	}{
+		{in: "30d", want: 30 * 24 * time.Hour},
+		{in: "0d", want: 0},
+		{in: "144h", want: 144 * time.Hour},
+		{in: "90m", want: 90 * time.Minute},
+		{in: "d", wantErr: true},
+		{in: "1.5d", wantErr: true},
+		{in: "6x", wantErr: true},
+		{in: "", wantErr: true},
+	} {
+		t.Run(tc.in, func(t *testing.T) {
+			got, err := ParseDays(tc.in)
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("ParseDays(%q) = %v, wantErr %v", tc.in, err, tc.wantErr)
+			}
+
+			if got != tc.want {
+				t.Errorf("ParseDays(%q) = %s, want %s", tc.in, got, tc.want)
+			}
+		})
+	}
+}
+
+func TestIssuanceConfigNotAfterTime(t *testing.T) {
+	now := time.Date(2025, 3, 16, 0, 0, 0, 0, time.UTC)
+
+	for _, tc := range []struct {
+		notAfter string
+		want     time.Time
+		wantErr  bool
+	}{
+		{notAfter: "", want: time.Time{}},
+		{notAfter: "6d", want: now.Add(6 * 24 * time.Hour)},
+		{notAfter: "144h", want: now.Add(144 * time.Hour)},
+		{notAfter: "0d", wantErr: true},
+		{notAfter: "-1h", wantErr: true},
+		{notAfter: "six days", wantErr: true},
+	} {
+		t.Run(tc.notAfter, func(t *testing.T) {
+			got, err := IssuanceConfig{NotAfter: tc.notAfter}.NotAfterTime(now)
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("NotAfterTime() = %v, wantErr %v", err, tc.wantErr)
+			}
+
+			if !got.Equal(tc.want) {
+				t.Errorf("NotAfterTime() = %s, want %s", got, tc.want)
+			}
+		})
+	}
+}
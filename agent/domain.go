@@ -0,0 +1,112 @@
+package agent
+
+import (
+	"fmt"
+	"strings"
+
+	"golang.org/x/net/idna"
+)
+
+// idnaProfile converts the domains into A-labels with the validation for the hostnames.
+var idnaProfile = idna.New(
+	idna.MapForLookup(),
+	idna.Transitional(false),
+	idna.BidiRule(),
+	idna.StrictDomainName(true),
+	idna.VerifyDNSLength(true),
+)
+
+// wildcardPathPrefix replaces the wildcard label in the storage paths and the local directories
+// so that `*` never reaches the shell globbing. `_` is not allowed in the hostnames issued by ACME CAs
+// so that the encoding is reversible.
+const wildcardPathPrefix = "_wildcard."
+
+// IsWildcard reports whether the domain is a wildcard domain such as *.example.com.
+func IsWildcard(domain string) bool {
+	return strings.HasPrefix(domain, "*.")
+}
+
+// NormalizeDomain converts the domain into the lowercase A-labels such as xn--bcher-kva.example
+// so that the domain given in Unicode is ordered and stored in the same way as the one in ASCII.
+// It returns the error for the invalid labels.
+func NormalizeDomain(domain string) (string, error) {
+	name := strings.TrimSuffix(domain, ".")
+
+	var prefix string
+	if strings.HasPrefix(name, "*.") {
+		prefix, name = "*.", name[2:]
+	}
+
+	// the wildcard in the other labels is rejected by ValidateDomain with the clear error
+	if !strings.Contains(name, "*") && name != "" {
+		ascii, err := idnaProfile.ToASCII(name)
+		if err != nil {
+			return "", fmt.Errorf("aaa: '%s' is not a valid domain: %w", domain, err)
+		}
+
+		name = ascii
+	}
+
+	normalized := prefix + name
+	if err := ValidateDomain(normalized); err != nil {
+		return "", err
+	}
+
+	return normalized, nil
+}
+
+// DisplayDomain returns the domain in U-labels such as bücher.example for the output to humans.
+// The domain is returned as is if it can't be converted.
+func DisplayDomain(domain string) string {
+	name := strings.TrimPrefix(domain, "*.")
+
+	unicode, err := idna.Display.ToUnicode(name)
+	if err != nil {
+		return domain
+	}
+
+	return strings.TrimSuffix(domain, name) + unicode
+}
+
+// ValidateDomain ensures that the domain can be requested to the CA.
+// The wildcard is only allowed as the entire leftmost label and it requires DNS-01 challenge.
+func ValidateDomain(domain string) error {
+	if domain == "" {
+		return fmt.Errorf("aaa: empty domain")
+	}
+
+	labels := strings.Split(strings.TrimPrefix(domain, "*."), ".")
+	for _, label := range labels {
+		if label == "" {
+			return fmt.Errorf("aaa: '%s' has an empty label", domain)
+		}
+
+		if strings.Contains(label, "*") {
+			return fmt.Errorf("aaa: '%s' has the wildcard that is not the entire leftmost label", domain)
+		}
+	}
+
+	if IsWildcard(domain) && len(labels) < 2 {
+		return fmt.Errorf("aaa: the wildcard '%s' must be under a registered domain", domain)
+	}
+
+	return nil
+}
+
+// EncodeDomainPath returns the name of the directory for the domain. *.example.com is encoded to _wildcard.example.com.
+func EncodeDomainPath(domain string) string {
+	if IsWildcard(domain) {
+		return wildcardPathPrefix + strings.TrimPrefix(domain, "*.")
+	}
+
+	return domain
+}
+
+// DecodeDomainPath returns the domain for the name of the directory encoded by EncodeDomainPath.
+func DecodeDomainPath(name string) string {
+	if strings.HasPrefix(name, wildcardPathPrefix) {
+		return "*." + strings.TrimPrefix(name, wildcardPathPrefix)
+	}
+
+	return name
+}
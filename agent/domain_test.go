@@ -0,0 +1,119 @@
+package agent
+
+import "testing"
+
+func TestValidateDomain(t *testing.T) {
+	for _, tc := range []struct {
+		domain  string
+		wantErr bool
+	}{
+		{domain: "example.com"},
+		{domain: "a.b.example.com"},
+		{domain: "*.example.com"},
+		{domain: "*.a.example.com"},
+		{domain: "", wantErr: true},
+		{domain: "*.com", wantErr: true},
+		{domain: "a.*.example.com", wantErr: true},
+		{domain: "*a.example.com", wantErr: true},
+		{domain: "**.example.com", wantErr: true},
+		{domain: "a..example.com", wantErr: true},
+		{domain: ".example.com", wantErr: true},
+	} {
+		t.Run(tc.domain, func(t *testing.T) {
+			err := ValidateDomain(tc.domain)
+			if (err != nil) != tc.wantErr {
+				t.Errorf("ValidateDomain(%q) = %v, wantErr %v", tc.domain, err, tc.wantErr)
+			}
+		})
+	}
+}
+
+func TestEncodeDomainPath(t *testing.T) {
+	for _, tc := range []struct {
+		domain string
+		want   string
+	}{
+		{domain: "example.com", want: "example.com"},
+		{domain: "*.example.com", want: "_wildcard.example.com"},
+		{domain: "*.a.example.com", want: "_wildcard.a.example.com"},
+		{domain: "xn--bcher-kva.example", want: "xn--bcher-kva.example"},
+	} {
+		t.Run(tc.domain, func(t *testing.T) {
+			got := EncodeDomainPath(tc.domain)
+			if got != tc.want {
+				t.Errorf("EncodeDomainPath(%q) = %q, want %q", tc.domain, got, tc.want)
+			}
+
+			if back := DecodeDomainPath(got); back != tc.domain {
+				t.Errorf("DecodeDomainPath(%q) = %q, want %q", got, back, tc.domain)
+			}
+		})
+	}
+}
+
+func TestDecodeDomainPath(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		want string
+	}{
+		{name: "example.com", want: "example.com"},
+		{name: "_wildcard.example.com", want: "*.example.com"},
+
+		// stored verbatim by the older versions
+		{name: "*.example.com", want: "*.example.com"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := DecodeDomainPath(tc.name); got != tc.want {
+				t.Errorf("DecodeDomainPath(%q) = %q, want %q", tc.name, got, tc.want)
+			}
+		})
+	}
+}
+
+func TestNormalizeDomain(t *testing.T) {
+	for _, tc := range []struct {
+		domain  string
+		want    string
+		wantErr bool
+	}{
+		{domain: "example.com", want: "example.com"},
+		{domain: "Example.COM.", want: "example.com"},
+		{domain: "bücher.example", want: "xn--bcher-kva.example"},
+		{domain: "BÜCHER.example", want: "xn--bcher-kva.example"},
+		{domain: "xn--bcher-kva.example", want: "xn--bcher-kva.example"},
+		{domain: "*.bücher.example", want: "*.xn--bcher-kva.example"},
+		{domain: "*.Example.com", want: "*.example.com"},
+		{domain: "bad_name.example", wantErr: true},
+		{domain: "a.*.example.com", wantErr: true},
+		{domain: "*.com", wantErr: true},
+		{domain: "", wantErr: true},
+	} {
+		t.Run(tc.domain, func(t *testing.T) {
+			got, err := NormalizeDomain(tc.domain)
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("NormalizeDomain(%q) = %v, wantErr %v", tc.domain, err, tc.wantErr)
+			}
+
+			if got != tc.want {
+				t.Errorf("NormalizeDomain(%q) = %q, want %q", tc.domain, got, tc.want)
+			}
+		})
+	}
+}
+
+func TestDisplayDomain(t *testing.T) {
+	for _, tc := range []struct {
+		domain string
+		want   string
+	}{
+		{domain: "example.com", want: "example.com"},
+		{domain: "xn--bcher-kva.example", want: "bücher.example"},
+		{domain: "*.xn--bcher-kva.example", want: "*.bücher.example"},
+	} {
+		t.Run(tc.domain, func(t *testing.T) {
+			if got := DisplayDomain(tc.domain); got != tc.want {
+				t.Errorf("DisplayDomain(%q) = %q, want %q", tc.domain, got, tc.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,193 @@
+package agent
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/x509"
+	"fmt"
+	"time"
+
+	"github.com/go-acme/lego/v4/certcrypto"
+	"github.com/pavlo-v-chernykh/keystore-go/v4"
+	"software.sslmate.com/src/go-pkcs12"
+)
+
+// Export formats.
+const (
+	ExportFormatPEM = "pem"
+	ExportFormatDER = "der"
+	ExportFormatPFX = "pfx"
+	ExportFormatJKS = "jks"
+)
+
+// ExportFile is a file built from the certificate and the private key.
+type ExportFile struct {
+	Name string
+	Data []byte
+}
+
+// Exporter builds the files in Format from the certificate bundle and the private key.
+type Exporter struct {
+	Format string
+
+	// Password protects PKCS#12 and JKS keystores.
+	Password string
+
+	// Alias is the alias of the private key entry in JKS.
+	Alias string
+}
+
+// Export returns the files in the format.
+func (e *Exporter) Export(bundle *CertBundle, key crypto.PrivateKey) ([]ExportFile, error) {
+	switch e.Format {
+	case ExportFormatPEM:
+		return []ExportFile{
+			{Name: "privkey.pem", Data: certcrypto.PEMEncode(key)},
+			{Name: "cert.pem", Data: bundle.CertPEM()},
+			{Name: "leaf.pem", Data: bundle.LeafPEM()},
+			{Name: "chain.pem", Data: bundle.ChainPEM()},
+			{Name: "fullchain.pem", Data: bundle.FullChainPEM()},
+		}, nil
+
+	case ExportFormatDER:
+		keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+		if err != nil {
+			return nil, fmt.Errorf("encoding the private key: %w", err)
+		}
+
+		files := []ExportFile{
+			{Name: "privkey.der", Data: keyDER},
+			{Name: "cert.der", Data: bundle.Leaf.Raw},
+		}
+
+		// DER holds a single certificate so that each intermediate is written into the numbered file
+		for i, cert := range bundle.Chain {
+			files = append(files, ExportFile{Name: fmt.Sprintf("chain-%d.der", i+1), Data: cert.Raw})
+		}
+
+		return files, nil
+
+	case ExportFormatPFX:
+		pfx, err := pkcs12.Modern2023.Encode(key, bundle.Leaf, bundle.Chain, e.Password)
+		if err != nil {
+			return nil, fmt.Errorf("encoding PKCS#12: %w", err)
+		}
+
+		return []ExportFile{{Name: "cert.p12", Data: pfx}}, nil
+
+	case ExportFormatJKS:
+		jks, err := e.encodeJKS(bundle, key)
+		if err != nil {
+			return nil, fmt.Errorf("encoding JKS: %w", err)
+		}
+
+		return []ExportFile{{Name: "keystore.jks", Data: jks}}, nil
+
+	default:
+		return nil, fmt.Errorf("aaa: unknown export format '%s'", e.Format)
+	}
+}
+
+// Match reports whether data exported previously holds the same certificate bundle and private key as f.
+// PKCS#12 and JKS keystores are compared by their content since they are encrypted with the random salt.
+func (e *Exporter) Match(f ExportFile, data []byte, bundle *CertBundle, key crypto.PrivateKey) bool {
+	switch e.Format {
+	case ExportFormatPFX:
+		curKey, curLeaf, curChain, err := pkcs12.DecodeChain(data, e.Password)
+		if err != nil {
+			return false
+		}
+
+		return equalPrivateKey(curKey, key) && equalCerts(append([]*x509.Certificate{curLeaf}, curChain...), bundle.certs())
+
+	case ExportFormatJKS:
+		ks := keystore.New()
+		if err := ks.Load(bytes.NewReader(data), []byte(e.Password)); err != nil {
+			return false
+		}
+
+		entry, err := ks.GetPrivateKeyEntry(e.Alias, []byte(e.Password))
+		if err != nil {
+			return false
+		}
+
+		want, err := e.privateKeyEntry(bundle, key)
+		if err != nil || !bytes.Equal(entry.PrivateKey, want.PrivateKey) || len(entry.CertificateChain) != len(want.CertificateChain) {
+			return false
+		}
+
+		for i := range want.CertificateChain {
+			if !bytes.Equal(entry.CertificateChain[i].Content, want.CertificateChain[i].Content) {
+				return false
+			}
+		}
+
+		return true
+
+	default:
+		return bytes.Equal(data, f.Data)
+	}
+}
+
+func (e *Exporter) encodeJKS(bundle *CertBundle, key crypto.PrivateKey) ([]byte, error) {
+	entry, err := e.privateKeyEntry(bundle, key)
+	if err != nil {
+		return nil, err
+	}
+
+	ks := keystore.New()
+	if err := ks.SetPrivateKeyEntry(e.Alias, entry, []byte(e.Password)); err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	if err := ks.Store(&buf, []byte(e.Password)); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+func (e *Exporter) privateKeyEntry(bundle *CertBundle, key crypto.PrivateKey) (keystore.PrivateKeyEntry, error) {
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return keystore.PrivateKeyEntry{}, fmt.Errorf("encoding the private key: %w", err)
+	}
+
+	certs := bundle.certs()
+	chain := make([]keystore.Certificate, len(certs))
+	for i, cert := range certs {
+		chain[i] = keystore.Certificate{Type: "X509", Content: cert.Raw}
+	}
+
+	return keystore.PrivateKeyEntry{
+		CreationTime:     time.Now(),
+		PrivateKey:       keyDER,
+		CertificateChain: chain,
+	}, nil
+}
+
+// certs returns the leaf and the intermediate certificates.
+func (b *CertBundle) certs() []*x509.Certificate {
+	return append([]*x509.Certificate{b.Leaf}, b.Chain...)
+}
+
+func equalPrivateKey(a, b crypto.PrivateKey) bool {
+	k, ok := a.(interface{ Equal(crypto.PrivateKey) bool })
+
+	return ok && k.Equal(b)
+}
+
+func equalCerts(a, b []*x509.Certificate) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	for i := range a {
+		if !a[i].Equal(b[i]) {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,138 @@
+package agent
+
+import (
+	"bytes"
+	"crypto"
+	"slices"
+	"testing"
+)
+
+func TestExporterMatch(t *testing.T) {
+	bundle, key := newTestBundle(t)
+	renewed, renewedKey := newTestBundle(t)
+
+	for _, format := range []string{ExportFormatPEM, ExportFormatDER, ExportFormatPFX, ExportFormatJKS} {
+		t.Run(format, func(t *testing.T) {
+			e := &Exporter{Format: format, Password: "changeit", Alias: "example.com"}
+
+			current, err := e.Export(bundle, key)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			for _, tc := range []struct {
+				name   string
+				bundle *CertBundle
+				key    crypto.PrivateKey
+				data   func(ExportFile) []byte
+				want   bool
+			}{
+				{
+					name:   "same content",
+					bundle: bundle,
+					key:    key,
+					want:   true,
+				},
+				{
+					name:   "renewed",
+					bundle: renewed,
+					key:    renewedKey,
+					want:   false,
+				},
+				{
+					name:   "missing",
+					bundle: bundle,
+					key:    key,
+					data:   func(ExportFile) []byte { return nil },
+					want:   false,
+				},
+				{
+					name:   "corrupted",
+					bundle: bundle,
+					key:    key,
+					data:   func(f ExportFile) []byte { return append([]byte("x"), f.Data...) },
+					want:   false,
+				},
+			} {
+				t.Run(tc.name, func(t *testing.T) {
+					// the keystores are encrypted with the random salt so that they never match byte by byte
+					files, err := e.Export(tc.bundle, tc.key)
+					if err != nil {
+						t.Fatal(err)
+					}
+
+					if len(files) != len(current) {
+						t.Fatalf("len(files) = %d, want %d", len(files), len(current))
+					}
+
+					for i, f := range files {
+						data := current[i].Data
+						if tc.data != nil {
+							data = tc.data(current[i])
+						}
+
+						if got := e.Match(f, data, tc.bundle, tc.key); got != tc.want {
+							t.Errorf("Match(%s) = %v, want %v", f.Name, got, tc.want)
+						}
+					}
+				})
+			}
+		})
+	}
+}
+
+func TestExporterExportDERChain(t *testing.T) {
+	bundle, key := newTestBundle(t)
+
+	files, err := (&Exporter{Format: ExportFormatDER}).Export(bundle, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var names []string
+	for _, f := range files {
+		names = append(names, f.Name)
+	}
+
+	if want := []string{"privkey.der", "cert.der", "chain-1.der"}; !slices.Equal(names, want) {
+		t.Errorf("files = %v, want %v", names, want)
+	}
+}
+
+func TestExporterExportPEMCert(t *testing.T) {
+	bundle, key := newTestBundle(t)
+
+	stored, err := ParseCertBundle(bundle.FullChainPEM())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		name   string
+		bundle *CertBundle
+		want   []byte
+	}{
+		{name: "as stored", bundle: stored, want: bundle.FullChainPEM()},
+		{name: "not parsed from PEM", bundle: bundle, want: bundle.LeafPEM()},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			files, err := (&Exporter{Format: ExportFormatPEM}).Export(tc.bundle, key)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			got := map[string][]byte{}
+			for _, f := range files {
+				got[f.Name] = f.Data
+			}
+
+			if !bytes.Equal(got["cert.pem"], tc.want) {
+				t.Errorf("cert.pem = %q, want %q", got["cert.pem"], tc.want)
+			}
+
+			if !bytes.Equal(got["leaf.pem"], bundle.LeafPEM()) {
+				t.Errorf("leaf.pem = %q, want the leaf only", got["leaf.pem"])
+			}
+		})
+	}
+}
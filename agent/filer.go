@@ -4,13 +4,19 @@ import (
 	"bytes"
 	"context"
 	"errors"
+	"fmt"
 	"io"
 	"os"
 	"path/filepath"
+	"slices"
+	"strconv"
 	"strings"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
 	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/smithy-go"
+	vault "github.com/hashicorp/vault/api"
 	"github.com/nabeken/aws-go-s3/v2/bucket"
 	"github.com/nabeken/aws-go-s3/v2/bucket/option"
 )
@@ -18,6 +24,9 @@ import (
 var (
 	// ErrFileNotFound is the error returned by Filer interface when file is not found.
 	ErrFileNotFound = errors.New("aaa: file not found")
+
+	// ErrVersionMismatch is the error returned by ConditionalWriter when the file has been updated by others.
+	ErrVersionMismatch = errors.New("aaa: version mismatch")
 )
 
 // Filer interface represents a file storage layer for AAA.
@@ -29,6 +38,26 @@ type Filer interface {
 	Split(elem string) []string
 }
 
+// Versioner is implemented by Filer that can tell the version of the file without reading it.
+type Versioner interface {
+	// Version returns an opaque string that changes whenever the file is updated.
+	Version(context.Context, string) (string, error)
+}
+
+// ConditionalWriter is implemented by Filer that can write the file only if it has not been updated
+// since its version is read by Versioner.
+type ConditionalWriter interface {
+	// WriteFileIfMatch writes data only if the current version of the file is version.
+	// The empty version means that the file must not exist. It returns ErrVersionMismatch if the condition fails.
+	WriteFileIfMatch(ctx context.Context, filename string, data []byte, version string) error
+}
+
+// Remover is implemented by Filer that can remove the file.
+type Remover interface {
+	// RemoveFile removes the file. It does not fail if the file does not exist.
+	RemoveFile(context.Context, string) error
+}
+
 // OSFiler implements Filer interface backed by *os.File.
 type OSFiler struct {
 	// BaseDir is prepended into given filename.
@@ -69,6 +98,33 @@ func (f *OSFiler) ListDir(_ context.Context, prefix string) ([]string, error) {
 	return fi.Readdirnames(-1)
 }
 
+func (f *OSFiler) Version(_ context.Context, filename string) (string, error) {
+	fi, err := os.Stat(f.Join(f.BaseDir, filename))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return "", ErrFileNotFound
+		}
+
+		return "", err
+	}
+
+	return fmt.Sprintf("%d-%d", fi.ModTime().UnixNano(), fi.Size()), nil
+}
+
+// RemoveFile removes the file and its directory if it becomes empty so that ListDir no longer returns it.
+func (f *OSFiler) RemoveFile(_ context.Context, filename string) error {
+	path := f.Join(f.BaseDir, filename)
+
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	// the directory still has other files
+	_ = os.Remove(filepath.Dir(path))
+
+	return nil
+}
+
 func (s *OSFiler) Join(elem ...string) string {
 	return filepath.Join(elem...)
 }
@@ -104,6 +160,36 @@ func (f *S3Filer) WriteFile(ctx context.Context, key string, data []byte) error
 	return err
 }
 
+func (f *S3Filer) WriteFileIfMatch(ctx context.Context, key string, data []byte, version string) error {
+	cl := int64(len(data))
+
+	_, err := f.bucket.PutObject(
+		ctx,
+		key,
+		bytes.NewReader(data),
+		option.SSEKMSKeyID(f.keyId),
+		option.ContentLength(cl),
+		option.ACLPrivate(),
+		func(req *s3.PutObjectInput) {
+			if version == "" {
+				req.IfNoneMatch = aws.String("*")
+			} else {
+				req.IfMatch = aws.String(version)
+			}
+		},
+	)
+
+	var apiErr smithy.APIError
+	if errors.As(err, &apiErr) {
+		switch apiErr.ErrorCode() {
+		case "PreconditionFailed", "ConditionalRequestConflict":
+			return ErrVersionMismatch
+		}
+	}
+
+	return err
+}
+
 func (s *S3Filer) ReadFile(ctx context.Context, key string) ([]byte, error) {
 	object, err := s.bucket.GetObject(ctx, key)
 	if err != nil {
@@ -145,6 +231,26 @@ func (s *S3Filer) ListDir(ctx context.Context, prefix string) ([]string, error)
 	return dirs, nil
 }
 
+// Version returns ETag of the object.
+func (s *S3Filer) Version(ctx context.Context, key string) (string, error) {
+	object, err := s.bucket.HeadObject(ctx, key)
+	if err != nil {
+		var notFoundErr *types.NotFound
+		if errors.As(err, &notFoundErr) {
+			return "", ErrFileNotFound
+		}
+
+		return "", err
+	}
+
+	return aws.ToString(object.ETag), nil
+}
+
+func (s *S3Filer) RemoveFile(ctx context.Context, key string) error {
+	_, err := s.bucket.DeleteObject(ctx, key)
+	return err
+}
+
 func (s *S3Filer) Join(elem ...string) string {
 	return strings.Join(elem, "/")
 }
@@ -152,3 +258,129 @@ func (s *S3Filer) Join(elem ...string) string {
 func (s *S3Filer) Split(prefix string) []string {
 	return strings.Split(prefix, "/")
 }
+
+// VaultFiler implements Filer interface backed by HashiCorp Vault KV v2 secrets engine.
+// Each file is stored as a secret that holds the content in the "data" field.
+type VaultFiler struct {
+	kv     *vault.KVv2
+	client *vault.Client
+	mount  string
+}
+
+func NewVaultFiler(client *vault.Client, mount string) *VaultFiler {
+	return &VaultFiler{
+		kv:     client.KVv2(mount),
+		client: client,
+		mount:  mount,
+	}
+}
+
+func (f *VaultFiler) WriteFile(ctx context.Context, key string, data []byte) error {
+	_, err := f.kv.Put(ctx, key, map[string]any{
+		"data": string(data),
+	})
+
+	return err
+}
+
+func (f *VaultFiler) WriteFileIfMatch(ctx context.Context, key string, data []byte, version string) error {
+	// check-and-set 0 allows the write only if the secret does not exist
+	cas := 0
+	if version != "" {
+		var err error
+		if cas, err = strconv.Atoi(version); err != nil {
+			return fmt.Errorf("aaa: invalid version '%s': %w", version, err)
+		}
+	}
+
+	_, err := f.kv.Put(ctx, key, map[string]any{
+		"data": string(data),
+	}, vault.WithCheckAndSet(cas))
+
+	var respErr *vault.ResponseError
+	if errors.As(err, &respErr) && slices.ContainsFunc(respErr.Errors, func(e string) bool {
+		return strings.Contains(e, "check-and-set")
+	}) {
+		return ErrVersionMismatch
+	}
+
+	return err
+}
+
+func (f *VaultFiler) ReadFile(ctx context.Context, key string) ([]byte, error) {
+	secret, err := f.kv.Get(ctx, key)
+	if err != nil {
+		if errors.Is(err, vault.ErrSecretNotFound) {
+			return nil, ErrFileNotFound
+		}
+
+		return nil, err
+	}
+
+	// the latest version has been deleted
+	if secret.Data == nil {
+		return nil, ErrFileNotFound
+	}
+
+	data, ok := secret.Data["data"].(string)
+	if !ok {
+		return nil, fmt.Errorf("aaa: '%s' does not have the data field", key)
+	}
+
+	return []byte(data), nil
+}
+
+// ListDir returns directories that has the given prefix.
+// It lists the keys in the metadata endpoint and picks up keys that end with '/'.
+func (f *VaultFiler) ListDir(ctx context.Context, prefix string) ([]string, error) {
+	secret, err := f.client.Logical().ListWithContext(ctx, f.mount+"/metadata/"+prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	// nothing is found under the prefix
+	if secret == nil || secret.Data == nil {
+		return nil, nil
+	}
+
+	keys, _ := secret.Data["keys"].([]any)
+
+	dirs := make([]string, 0, len(keys))
+	for _, v := range keys {
+		key, ok := v.(string)
+		if !ok || !strings.HasSuffix(key, "/") {
+			continue
+		}
+
+		dirs = append(dirs, f.Join(prefix, strings.TrimSuffix(key, "/")))
+	}
+
+	return dirs, nil
+}
+
+// Version returns the current version of the secret.
+func (f *VaultFiler) Version(ctx context.Context, key string) (string, error) {
+	md, err := f.kv.GetMetadata(ctx, key)
+	if err != nil {
+		if errors.Is(err, vault.ErrSecretNotFound) {
+			return "", ErrFileNotFound
+		}
+
+		return "", err
+	}
+
+	return strconv.Itoa(md.CurrentVersion), nil
+}
+
+// RemoveFile removes all the versions of the secret.
+func (f *VaultFiler) RemoveFile(ctx context.Context, key string) error {
+	return f.kv.DeleteMetadata(ctx, key)
+}
+
+func (f *VaultFiler) Join(elem ...string) string {
+	return strings.Join(elem, "/")
+}
+
+func (f *VaultFiler) Split(prefix string) []string {
+	return strings.Split(prefix, "/")
+}
@@ -0,0 +1,269 @@
+package agent
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+)
+
+// maxIndexUpdateAttempts limits the retries when the index is updated concurrently.
+const maxIndexUpdateAttempts = 5
+
+// States of the domain in the index.
+const (
+	// DomainStateIssued is the domain that has the certificate.
+	DomainStateIssued = "issued"
+
+	// DomainStateFailed is the domain whose last issuance has failed. It may still have the previous certificate.
+	DomainStateFailed = "failed"
+
+	// DomainStatePending is the domain that has the private key or the reference to it but not the certificate yet.
+	DomainStatePending = "pending"
+
+	// DomainStateOrphaned is the domain that has neither the certificate nor the private key.
+	DomainStateOrphaned = "orphaned"
+
+	// DomainStateUnreadable is the domain whose files can't be read such as the corrupted certificate.
+	DomainStateUnreadable = "unreadable"
+)
+
+// Index is the summary of all the certificates in the account so that listing requires a single read.
+// It is stored in {{email}}/index.json and updated on each SaveCert and SaveMetadata.
+type Index struct {
+	UpdatedAt time.Time              `json:"updated_at"`
+	Domains   map[string]*IndexEntry `json:"domains"`
+}
+
+// IndexEntry is the summary of the certificate for the domain.
+type IndexEntry struct {
+	State string `json:"state"`
+
+	NotBefore time.Time `json:"not_before"`
+	NotAfter  time.Time `json:"not_after"`
+	SAN       []string  `json:"san"`
+	Issuer    string    `json:"issuer"`
+	KeyType   string    `json:"key_type"`
+	Serial    string    `json:"serial"`
+
+	// ACMCertificateARNs are the ARNs of the certificate imported into ACM.
+	ACMCertificateARNs []string `json:"acm_certificate_arns,omitempty"`
+
+	LastFailedAt time.Time `json:"last_failed_at"`
+	LastError    string    `json:"last_error,omitempty"`
+
+	// Error is the reason why the domain is unreadable.
+	Error string `json:"error,omitempty"`
+}
+
+// HasCert reports whether the entry has the certificate.
+func (e *IndexEntry) HasCert() bool {
+	return !e.NotAfter.IsZero()
+}
+
+// apply updates the entry with the metadata.
+func (e *IndexEntry) apply(md *Metadata) {
+	e.ACMCertificateARNs = md.ACMARNs()
+	e.LastFailedAt = md.LastFailedAt
+	e.LastError = md.LastError
+
+	switch {
+	case md.LastFailedAt.After(md.LastIssuedAt):
+		e.State = DomainStateFailed
+	case e.HasCert():
+		e.State = DomainStateIssued
+	case e.State == "":
+		e.State = DomainStateOrphaned
+	}
+}
+
+// NewIndexEntry returns the summary of the certificate.
+func NewIndexEntry(cert *x509.Certificate) *IndexEntry {
+	issuer := cert.Issuer.CommonName
+	if issuer == "" {
+		issuer = cert.Issuer.String()
+	}
+
+	return &IndexEntry{
+		State:     DomainStateIssued,
+		NotBefore: cert.NotBefore,
+		NotAfter:  cert.NotAfter,
+		SAN:       cert.DNSNames,
+		Issuer:    issuer,
+		KeyType:   keyType(cert),
+		Serial:    cert.SerialNumber.Text(16),
+	}
+}
+
+// keyType returns the algorithm and the size of the public key such as RSA-2048 and ECDSA-P-256.
+func keyType(cert *x509.Certificate) string {
+	if t := PublicKeyType(cert.PublicKey); t != "" {
+		return t
+	}
+
+	return cert.PublicKeyAlgorithm.String()
+}
+
+// LoadIndex returns the index of the account. It returns ErrFileNotFound if the index has not been built yet.
+func (s *Store) LoadIndex(ctx context.Context) (*Index, error) {
+	blob, err := s.filer.ReadFile(ctx, s.joinPrefix("index.json"))
+	if err != nil {
+		return nil, err
+	}
+
+	var idx Index
+	if err := json.Unmarshal(blob, &idx); err != nil {
+		return nil, fmt.Errorf("parsing the index: %w", err)
+	}
+
+	if idx.Domains == nil {
+		idx.Domains = map[string]*IndexEntry{}
+	}
+
+	// the index built before the state was introduced has only the issued domains
+	for _, entry := range idx.Domains {
+		if entry.State == "" {
+			entry.State = DomainStateIssued
+		}
+	}
+
+	return &idx, nil
+}
+
+func (s *Store) SaveIndex(ctx context.Context, idx *Index) error {
+	idx.UpdatedAt = time.Now()
+
+	blob, err := json.Marshal(idx)
+	if err != nil {
+		return err
+	}
+
+	return s.filer.WriteFile(ctx, s.joinPrefix("index.json"), blob)
+}
+
+// BuildIndex builds the index by walking all the domains in the account.
+// The domains that can't be read are kept as unreadable with the error.
+func (s *Store) BuildIndex(ctx context.Context) (*Index, error) {
+	domains, err := s.ListDomains(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("listing the domains: %w", err)
+	}
+
+	idx := &Index{Domains: make(map[string]*IndexEntry, len(domains))}
+	for _, dom := range domains {
+		entry, err := s.LoadIndexEntry(ctx, dom)
+		if err != nil {
+			slog.WarnContext(ctx, "failed to read the domain", "email", s.email, "domain", dom, "error", err)
+			entry = &IndexEntry{State: DomainStateUnreadable, Error: err.Error()}
+		}
+
+		idx.Domains[dom] = entry
+	}
+
+	return idx, nil
+}
+
+// LoadIndexEntry builds the index entry for the domain from the certificate, the private key and the metadata.
+func (s *Store) LoadIndexEntry(ctx context.Context, domain string) (*IndexEntry, error) {
+	entry := &IndexEntry{}
+
+	cert, err := s.LoadCert(ctx, domain)
+	switch {
+	case err == nil:
+		entry = NewIndexEntry(cert)
+	case !errors.Is(err, ErrFileNotFound):
+		return nil, fmt.Errorf("loading the certificate: %w", err)
+	default:
+		_, err := s.LoadCertKeyPEM(ctx, domain)
+		if errors.Is(err, ErrFileNotFound) {
+			_, err = s.LoadKeyRef(ctx, domain)
+		}
+
+		switch {
+		case err == nil:
+			entry.State = DomainStatePending
+		case !errors.Is(err, ErrFileNotFound):
+			return nil, fmt.Errorf("loading the private key: %w", err)
+		}
+	}
+
+	md, err := s.LoadMetadata(ctx, domain)
+	if err != nil {
+		return nil, fmt.Errorf("loading the metadata: %w", err)
+	}
+
+	entry.apply(md)
+
+	return entry, nil
+}
+
+// modifyIndex applies modify to the index and saves it if modify returns true.
+// The index is built from scratch if it does not exist so that the index always covers all the domains.
+// If the filer supports the conditional write, it retries on the concurrent update instead of overwriting it.
+func (s *Store) modifyIndex(ctx context.Context, modify func(*Index) bool) error {
+	path := s.joinPrefix("index.json")
+
+	versioner, _ := s.filer.(Versioner)
+	writer, _ := s.filer.(ConditionalWriter)
+	conditional := versioner != nil && writer != nil
+
+	for range maxIndexUpdateAttempts {
+		// the version must be read before the content so that the stale content never wins
+		var version string
+		if conditional {
+			v, err := versioner.Version(ctx, path)
+			if err != nil && !errors.Is(err, ErrFileNotFound) {
+				return err
+			}
+
+			version = v
+		}
+
+		idx, err := s.LoadIndex(ctx)
+		switch {
+		case errors.Is(err, ErrFileNotFound):
+			if idx, err = s.BuildIndex(ctx); err != nil {
+				return err
+			}
+		case err != nil:
+			return err
+		}
+
+		if !modify(idx) {
+			return nil
+		}
+
+		if !conditional {
+			return s.SaveIndex(ctx, idx)
+		}
+
+		idx.UpdatedAt = time.Now()
+
+		blob, err := json.Marshal(idx)
+		if err != nil {
+			return err
+		}
+
+		err = writer.WriteFileIfMatch(ctx, path, blob, version)
+		if errors.Is(err, ErrVersionMismatch) {
+			continue
+		}
+
+		return err
+	}
+
+	return errors.New("aaa: the index has been updated concurrently too many times")
+}
+
+// updateIndex updates the index after the data has been saved. The error must fail the caller
+// since the renewal relies on the index and the stale entry would be renewed on every run.
+func (s *Store) updateIndex(ctx context.Context, modify func(*Index) bool) error {
+	if err := s.modifyIndex(ctx, modify); err != nil {
+		return fmt.Errorf("updating the index: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,189 @@
+package agent
+
+import (
+	"context"
+	"maps"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestIndexEntryApply(t *testing.T) {
+	issuedAt := time.Date(2025, 3, 16, 0, 0, 0, 0, time.UTC)
+	notAfter := issuedAt.AddDate(0, 0, 90)
+
+	for _, tc := range []struct {
+		name      string
+		entry     IndexEntry
+		md        Metadata
+		wantState string
+	}{
+		{
+			name:      "issued",
+			entry:     IndexEntry{State: DomainStateIssued, NotAfter: notAfter},
+			md:        Metadata{LastIssuedAt: issuedAt},
+			wantState: DomainStateIssued,
+		},
+		{
+			name:      "renewal failed after the issuance",
+			entry:     IndexEntry{State: DomainStateIssued, NotAfter: notAfter},
+			md:        Metadata{LastIssuedAt: issuedAt, LastFailedAt: issuedAt.Add(time.Hour), LastError: "boom"},
+			wantState: DomainStateFailed,
+		},
+		{
+			name:      "renewed after the failure",
+			entry:     IndexEntry{State: DomainStateFailed, NotAfter: notAfter},
+			md:        Metadata{LastIssuedAt: issuedAt.Add(time.Hour), LastFailedAt: issuedAt, LastError: "boom"},
+			wantState: DomainStateIssued,
+		},
+		{
+			name:      "first issuance failed",
+			entry:     IndexEntry{State: DomainStatePending},
+			md:        Metadata{LastFailedAt: issuedAt, LastError: "boom"},
+			wantState: DomainStateFailed,
+		},
+		{
+			name:      "pending",
+			entry:     IndexEntry{State: DomainStatePending},
+			md:        Metadata{},
+			wantState: DomainStatePending,
+		},
+		{
+			name:      "metadata only",
+			entry:     IndexEntry{},
+			md:        Metadata{IssuedCount: 1, LastIssuedAt: issuedAt},
+			wantState: DomainStateOrphaned,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			entry := tc.entry
+			entry.apply(&tc.md)
+
+			if entry.State != tc.wantState {
+				t.Errorf("State = %q, want %q", entry.State, tc.wantState)
+			}
+
+			if !entry.LastFailedAt.Equal(tc.md.LastFailedAt) || entry.LastError != tc.md.LastError {
+				t.Errorf("LastFailedAt, LastError = %s, %q, want %s, %q", entry.LastFailedAt, entry.LastError, tc.md.LastFailedAt, tc.md.LastError)
+			}
+		})
+	}
+}
+
+func TestIndexEntryApplyACMARNs(t *testing.T) {
+	md := &Metadata{}
+	md.SetACMARN(ACMTarget{}, "arn:aws:acm:us-east-1:111111111111:certificate/b")
+	md.SetACMARN(ACMTarget{Region: "ap-northeast-1"}, "arn:aws:acm:ap-northeast-1:111111111111:certificate/a")
+
+	entry := &IndexEntry{}
+	entry.apply(md)
+
+	want := []string{
+		"arn:aws:acm:ap-northeast-1:111111111111:certificate/a",
+		"arn:aws:acm:us-east-1:111111111111:certificate/b",
+	}
+
+	if !slices.Equal(entry.ACMCertificateARNs, want) {
+		t.Errorf("ACMCertificateARNs = %v, want %v", entry.ACMCertificateARNs, want)
+	}
+}
+
+// racingFiler is OSFiler with the conditional write. The first write fails after race updates the file
+// as if another writer has updated it concurrently.
+type racingFiler struct {
+	*OSFiler
+
+	race   func()
+	writes int
+}
+
+func (f *racingFiler) WriteFileIfMatch(ctx context.Context, filename string, data []byte, version string) error {
+	f.writes++
+
+	if f.race != nil {
+		race := f.race
+		f.race = nil
+		race()
+
+		return ErrVersionMismatch
+	}
+
+	return f.WriteFile(ctx, filename, data)
+}
+
+func TestStoreModifyIndex(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("retried on the concurrent update", func(t *testing.T) {
+		filer := &OSFiler{BaseDir: t.TempDir()}
+		other := newTestStore(t, filer)
+
+		if err := other.SaveCert(ctx, "a.example.com", newTestCertPEM(t, "a.example.com")); err != nil {
+			t.Fatal(err)
+		}
+
+		racing := &racingFiler{OSFiler: filer}
+		racing.race = func() {
+			if err := other.SaveCert(ctx, "b.example.com", newTestCertPEM(t, "b.example.com")); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		if err := newTestStore(t, racing).SaveCert(ctx, "c.example.com", newTestCertPEM(t, "c.example.com")); err != nil {
+			t.Fatal(err)
+		}
+
+		if racing.writes != 2 {
+			t.Errorf("writes = %d, want 2", racing.writes)
+		}
+
+		assertIndexDomains(t, other, "a.example.com", "b.example.com", "c.example.com")
+	})
+
+	t.Run("built if missing", func(t *testing.T) {
+		filer := &OSFiler{BaseDir: t.TempDir()}
+		store := newTestStore(t, filer)
+
+		// the certificate written before the index is introduced
+		if err := filer.WriteFile(ctx, store.DomainPath("a.example.com", "cert.pem"), newTestCertPEM(t, "a.example.com")); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := store.SaveCert(ctx, "b.example.com", newTestCertPEM(t, "b.example.com")); err != nil {
+			t.Fatal(err)
+		}
+
+		assertIndexDomains(t, store, "a.example.com", "b.example.com")
+	})
+
+	t.Run("gives up after too many updates", func(t *testing.T) {
+		racing := &racingFiler{OSFiler: &OSFiler{BaseDir: t.TempDir()}}
+		store := newTestStore(t, racing)
+
+		var race func()
+		race = func() { racing.race = race }
+		racing.race = race
+
+		if err := store.SaveCert(ctx, "a.example.com", newTestCertPEM(t, "a.example.com")); err == nil {
+			t.Error("SaveCert() must fail")
+		}
+
+		if racing.writes != maxIndexUpdateAttempts {
+			t.Errorf("writes = %d, want %d", racing.writes, maxIndexUpdateAttempts)
+		}
+	})
+}
+
+func assertIndexDomains(t *testing.T, store *Store, want ...string) {
+	t.Helper()
+
+	idx, err := store.LoadIndex(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got := slices.Sorted(maps.Keys(idx.Domains))
+	if !slices.Equal(got, want) {
+		t.Errorf("domains in the index = %v, want %v", got, want)
+	}
+}
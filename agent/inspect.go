@@ -0,0 +1,64 @@
+package agent
+
+import (
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"strings"
+	"time"
+)
+
+// CertInfo is the details of the certificate that are usually inspected with openssl.
+type CertInfo struct {
+	Subject            string    `json:"subject"`
+	Issuer             string    `json:"issuer"`
+	SAN                []string  `json:"san,omitempty"`
+	Serial             string    `json:"serial"`
+	NotBefore          time.Time `json:"not_before"`
+	NotAfter           time.Time `json:"not_after"`
+	KeyType            string    `json:"key_type"`
+	SignatureAlgorithm string    `json:"signature_algorithm"`
+
+	// SHA256Fingerprint is the SHA-256 digest of the certificate in DER.
+	SHA256Fingerprint string `json:"sha256_fingerprint"`
+
+	// SPKIPin is the base64-encoded SHA-256 digest of the public key used for the key pinning.
+	SPKIPin string `json:"spki_pin"`
+
+	OCSPServers           []string `json:"ocsp_servers,omitempty"`
+	CRLDistributionPoints []string `json:"crl_distribution_points,omitempty"`
+	IssuingCertificateURL []string `json:"issuing_certificate_url,omitempty"`
+}
+
+// NewCertInfo returns the details of the certificate.
+func NewCertInfo(cert *x509.Certificate) *CertInfo {
+	fingerprint := sha256.Sum256(cert.Raw)
+	pin := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+
+	return &CertInfo{
+		Subject:               cert.Subject.String(),
+		Issuer:                cert.Issuer.String(),
+		SAN:                   cert.DNSNames,
+		Serial:                cert.SerialNumber.Text(16),
+		NotBefore:             cert.NotBefore,
+		NotAfter:              cert.NotAfter,
+		KeyType:               keyType(cert),
+		SignatureAlgorithm:    cert.SignatureAlgorithm.String(),
+		SHA256Fingerprint:     colonHex(fingerprint[:]),
+		SPKIPin:               base64.StdEncoding.EncodeToString(pin[:]),
+		OCSPServers:           cert.OCSPServer,
+		CRLDistributionPoints: cert.CRLDistributionPoints,
+		IssuingCertificateURL: cert.IssuingCertificateURL,
+	}
+}
+
+// colonHex encodes b in the same form as openssl such as AB:CD:EF.
+func colonHex(b []byte) string {
+	parts := make([]string, len(b))
+	for i := range b {
+		parts[i] = strings.ToUpper(hex.EncodeToString(b[i : i+1]))
+	}
+
+	return strings.Join(parts, ":")
+}
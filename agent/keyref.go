@@ -0,0 +1,129 @@
+package agent
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// Providers of the certificate private key kept outside of the store.
+const (
+	KeyProviderKMS    = "kms"
+	KeyProviderPKCS11 = "pkcs11"
+)
+
+// Types of the private key in the same form as IndexEntry.KeyType.
+const (
+	KeyTypeRSA2048   = "RSA-2048"
+	KeyTypeRSA4096   = "RSA-4096"
+	KeyTypeECDSAP256 = "ECDSA-P-256"
+	KeyTypeECDSAP384 = "ECDSA-P-384"
+)
+
+// ExternalSigner is the private key kept outside of the store such as AWS KMS and PKCS#11 tokens.
+// The private key never leaves there so that it can only sign.
+type ExternalSigner interface {
+	crypto.Signer
+
+	// Close releases the session to the key.
+	Close() error
+}
+
+// KeyRef is the reference to the certificate private key kept outside of the store.
+// It is stored instead of privkey.pem.
+type KeyRef struct {
+	Provider string `json:"provider"`
+	KeyType  string `json:"key_type"`
+
+	// KeyID is the ARN of the asymmetric KMS key.
+	KeyID string `json:"key_id,omitempty"`
+
+	// Module, TokenLabel and Label locate the key in the PKCS#11 token. The PIN is never stored.
+	Module     string `json:"module,omitempty"`
+	TokenLabel string `json:"token_label,omitempty"`
+	Label      string `json:"label,omitempty"`
+}
+
+func (r *KeyRef) Validate() error {
+	switch r.KeyType {
+	case KeyTypeRSA2048, KeyTypeRSA4096, KeyTypeECDSAP256, KeyTypeECDSAP384:
+	default:
+		return fmt.Errorf("aaa: unsupported key type '%s'", r.KeyType)
+	}
+
+	switch r.Provider {
+	case KeyProviderKMS:
+	case KeyProviderPKCS11:
+		if r.Module == "" || r.TokenLabel == "" || r.Label == "" {
+			return errors.New("aaa: module, token label and label are required for PKCS#11 key")
+		}
+	default:
+		return fmt.Errorf("aaa: unknown key provider '%s'", r.Provider)
+	}
+
+	return nil
+}
+
+// PublicKeyType returns the type of the public key such as RSA-2048 and ECDSA-P-256.
+// It returns an empty string if the key is neither RSA nor ECDSA.
+func PublicKeyType(pub crypto.PublicKey) string {
+	switch pub := pub.(type) {
+	case *rsa.PublicKey:
+		return fmt.Sprintf("RSA-%d", pub.N.BitLen())
+	case *ecdsa.PublicKey:
+		return "ECDSA-" + pub.Curve.Params().Name
+	default:
+		return ""
+	}
+}
+
+// String returns the human-readable location of the key.
+func (r *KeyRef) String() string {
+	if r.Provider == KeyProviderPKCS11 {
+		return fmt.Sprintf("pkcs11:token=%s;object=%s", r.TokenLabel, r.Label)
+	}
+
+	return r.Provider + ":" + r.KeyID
+}
+
+// SaveKeyRef saves the reference to the private key kept outside of the store.
+func (s *Store) SaveKeyRef(ctx context.Context, domain string, ref *KeyRef) error {
+	blob, err := json.Marshal(ref)
+	if err != nil {
+		return err
+	}
+
+	if err := s.writeDomainFile(ctx, domain, "keyref.json", blob); err != nil {
+		return err
+	}
+
+	return s.updateIndex(ctx, func(idx *Index) bool {
+		entry, ok := idx.Domains[domain]
+		if ok && entry.State != DomainStateOrphaned {
+			return false
+		}
+
+		idx.Domains[domain] = &IndexEntry{State: DomainStatePending}
+
+		return true
+	})
+}
+
+// LoadKeyRef returns the reference to the private key. It returns ErrFileNotFound if the key is in privkey.pem.
+func (s *Store) LoadKeyRef(ctx context.Context, domain string) (*KeyRef, error) {
+	blob, err := s.readDomainFile(ctx, domain, "keyref.json")
+	if err != nil {
+		return nil, err
+	}
+
+	ref := &KeyRef{}
+	if err := json.Unmarshal(blob, ref); err != nil {
+		return nil, fmt.Errorf("parsing the key reference: %w", err)
+	}
+
+	return ref, nil
+}
@@ -0,0 +1,143 @@
+package agent
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/x509"
+	"fmt"
+	"io"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/kms"
+	"github.com/aws/aws-sdk-go-v2/service/kms/types"
+)
+
+// kmsKeySpecs maps the key types to the KMS key specs.
+var kmsKeySpecs = map[string]types.KeySpec{
+	KeyTypeRSA2048:   types.KeySpecRsa2048,
+	KeyTypeRSA4096:   types.KeySpecRsa4096,
+	KeyTypeECDSAP256: types.KeySpecEccNistP256,
+	KeyTypeECDSAP384: types.KeySpecEccNistP384,
+}
+
+// KMSSigner signs with the asymmetric KMS key.
+type KMSSigner struct {
+	client *kms.Client
+	keyID  string
+	pub    crypto.PublicKey
+}
+
+// NewKMSSigner returns the signer for the existing KMS key.
+func NewKMSSigner(ctx context.Context, client *kms.Client, keyID string) (*KMSSigner, error) {
+	resp, err := client.GetPublicKey(ctx, &kms.GetPublicKeyInput{KeyId: aws.String(keyID)})
+	if err != nil {
+		return nil, fmt.Errorf("getting the public key of '%s': %w", keyID, err)
+	}
+
+	if resp.KeyUsage != types.KeyUsageTypeSignVerify {
+		return nil, fmt.Errorf("aaa: the KMS key '%s' is not for signing", keyID)
+	}
+
+	pub, err := x509.ParsePKIXPublicKey(resp.PublicKey)
+	if err != nil {
+		return nil, fmt.Errorf("parsing the public key of '%s': %w", keyID, err)
+	}
+
+	return &KMSSigner{
+		client: client,
+		keyID:  aws.ToString(resp.KeyId),
+		pub:    pub,
+	}, nil
+}
+
+// CreateKMSKey creates the asymmetric KMS key for signing.
+func CreateKMSKey(ctx context.Context, client *kms.Client, keyType, description string) (*KMSSigner, error) {
+	spec, ok := kmsKeySpecs[keyType]
+	if !ok {
+		return nil, fmt.Errorf("aaa: unsupported key type '%s' for KMS", keyType)
+	}
+
+	resp, err := client.CreateKey(ctx, &kms.CreateKeyInput{
+		KeySpec:     spec,
+		KeyUsage:    types.KeyUsageTypeSignVerify,
+		Description: aws.String(description),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("creating the KMS key: %w", err)
+	}
+
+	return NewKMSSigner(ctx, client, aws.ToString(resp.KeyMetadata.Arn))
+}
+
+// KeyID returns the ARN of the key.
+func (s *KMSSigner) KeyID() string {
+	return s.keyID
+}
+
+func (s *KMSSigner) Public() crypto.PublicKey {
+	return s.pub
+}
+
+// Sign signs the digest in KMS. The signature is in the same form as crypto/rsa and crypto/ecdsa.
+func (s *KMSSigner) Sign(_ io.Reader, digest []byte, opts crypto.SignerOpts) ([]byte, error) {
+	alg, err := s.signingAlgorithm(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := s.client.Sign(context.Background(), &kms.SignInput{
+		KeyId:            aws.String(s.keyID),
+		Message:          digest,
+		MessageType:      types.MessageTypeDigest,
+		SigningAlgorithm: alg,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("signing with '%s': %w", s.keyID, err)
+	}
+
+	return resp.Signature, nil
+}
+
+func (s *KMSSigner) Close() error {
+	return nil
+}
+
+func (s *KMSSigner) signingAlgorithm(opts crypto.SignerOpts) (types.SigningAlgorithmSpec, error) {
+	hash := opts.HashFunc()
+
+	switch s.pub.(type) {
+	case *rsa.PublicKey:
+		if _, ok := opts.(*rsa.PSSOptions); ok {
+			switch hash {
+			case crypto.SHA256:
+				return types.SigningAlgorithmSpecRsassaPssSha256, nil
+			case crypto.SHA384:
+				return types.SigningAlgorithmSpecRsassaPssSha384, nil
+			case crypto.SHA512:
+				return types.SigningAlgorithmSpecRsassaPssSha512, nil
+			}
+		} else {
+			switch hash {
+			case crypto.SHA256:
+				return types.SigningAlgorithmSpecRsassaPkcs1V15Sha256, nil
+			case crypto.SHA384:
+				return types.SigningAlgorithmSpecRsassaPkcs1V15Sha384, nil
+			case crypto.SHA512:
+				return types.SigningAlgorithmSpecRsassaPkcs1V15Sha512, nil
+			}
+		}
+	case *ecdsa.PublicKey:
+		switch hash {
+		case crypto.SHA256:
+			return types.SigningAlgorithmSpecEcdsaSha256, nil
+		case crypto.SHA384:
+			return types.SigningAlgorithmSpecEcdsaSha384, nil
+		case crypto.SHA512:
+			return types.SigningAlgorithmSpecEcdsaSha512, nil
+		}
+	}
+
+	return "", fmt.Errorf("aaa: unsupported signing with %s for %T in KMS", hash, s.pub)
+}
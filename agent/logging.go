@@ -1,7 +1,61 @@
 package agent
 
-import "log"
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
 
-func Debug(v ...any) {
-	log.Print(append([]any{"DEBUG: "}, v...)...)
+	legolog "github.com/go-acme/lego/v4/log"
+)
+
+// NewLogger returns a new structured logger writing to w.
+// format must be "json" or "text" and level is one of "debug", "info", "warn" and "error".
+func NewLogger(w io.Writer, format, level string) (*slog.Logger, error) {
+	var lvl slog.Level
+	if err := lvl.UnmarshalText([]byte(level)); err != nil {
+		return nil, fmt.Errorf("aaa: invalid log level '%s'", level)
+	}
+
+	opts := &slog.HandlerOptions{Level: lvl}
+
+	switch strings.ToLower(format) {
+	case "json":
+		return slog.New(slog.NewJSONHandler(w, opts)), nil
+	case "text":
+		return slog.New(slog.NewTextHandler(w, opts)), nil
+	default:
+		return nil, fmt.Errorf("aaa: invalid log format '%s'", format)
+	}
+}
+
+// SetupLoggerFromEnv sets the default logger with AAA_LOG_FORMAT and AAA_LOG_LEVEL.
+// It is intended to be used in the Lambda functions so it defaults to JSON at info level.
+func SetupLoggerFromEnv() error {
+	format := os.Getenv("AAA_LOG_FORMAT")
+	if format == "" {
+		format = "json"
+	}
+
+	level := os.Getenv("AAA_LOG_LEVEL")
+	if level == "" {
+		level = "info"
+	}
+
+	logger, err := NewLogger(os.Stderr, format, level)
+	if err != nil {
+		return err
+	}
+
+	SetDefaultLogger(logger)
+
+	return nil
+}
+
+// SetDefaultLogger sets logger as the default logger.
+// The logger of lego is also redirected to logger.
+func SetDefaultLogger(logger *slog.Logger) {
+	slog.SetDefault(logger)
+	legolog.Logger = slog.NewLogLogger(logger.Handler(), slog.LevelInfo)
 }
@@ -0,0 +1,74 @@
+package agent
+
+import (
+	"slices"
+	"time"
+)
+
+// Metadata is a data persisted on the storage per domain to track the issuance.
+type Metadata struct {
+	IssuedCount  int       `json:"issued_count"`
+	FailedCount  int       `json:"failed_count"`
+	LastIssuedAt time.Time `json:"last_issued_at"`
+	LastFailedAt time.Time `json:"last_failed_at"`
+	LastError    string    `json:"last_error,omitempty"`
+
+	// ACMCertificateARN is the ARN of the certificate imported into ACM first.
+	// The later imports reuse it to renew the certificate in place.
+	ACMCertificateARN string `json:"acm_certificate_arn,omitempty"`
+
+	// ACMCertificateARNs is the same as ACMCertificateARN but for the non-default ACM targets.
+	ACMCertificateARNs map[string]string `json:"acm_certificate_arns,omitempty"`
+}
+
+// ACMARN returns the ARN of the certificate imported into the target.
+func (md *Metadata) ACMARN(target ACMTarget) string {
+	if target.IsDefault() {
+		return md.ACMCertificateARN
+	}
+
+	return md.ACMCertificateARNs[target.Key()]
+}
+
+// SetACMARN sets the ARN of the certificate imported into the target.
+func (md *Metadata) SetACMARN(target ACMTarget, arn string) {
+	if target.IsDefault() {
+		md.ACMCertificateARN = arn
+		return
+	}
+
+	if md.ACMCertificateARNs == nil {
+		md.ACMCertificateARNs = map[string]string{}
+	}
+
+	md.ACMCertificateARNs[target.Key()] = arn
+}
+
+// ACMARNs returns the ARNs of the certificate imported into all the ACM targets.
+func (md *Metadata) ACMARNs() []string {
+	var arns []string
+	if md.ACMCertificateARN != "" {
+		arns = append(arns, md.ACMCertificateARN)
+	}
+
+	for _, arn := range md.ACMCertificateARNs {
+		arns = append(arns, arn)
+	}
+
+	slices.Sort(arns)
+
+	return arns
+}
+
+// RecordSuccess records the successful issuance at t.
+func (md *Metadata) RecordSuccess(t time.Time) {
+	md.IssuedCount++
+	md.LastIssuedAt = t
+}
+
+// RecordFailure records the failed issuance at t with its error.
+func (md *Metadata) RecordFailure(t time.Time, err error) {
+	md.FailedCount++
+	md.LastFailedAt = t
+	md.LastError = err.Error()
+}
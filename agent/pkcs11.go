@@ -0,0 +1,78 @@
+//go:build pkcs11
+
+package agent
+
+import (
+	"crypto/elliptic"
+	"errors"
+	"fmt"
+
+	"github.com/ThalesIgnite/crypto11"
+)
+
+// pkcs11Signer holds the session to the token while the key is used.
+type pkcs11Signer struct {
+	crypto11.Signer
+
+	ctx *crypto11.Context
+}
+
+func (s *pkcs11Signer) Close() error {
+	return s.ctx.Close()
+}
+
+// OpenPKCS11Key returns the signer for the key labeled in the token.
+// If create is true, the key is generated in the token if it does not exist.
+func OpenPKCS11Key(ref *KeyRef, pin string, create bool) (ExternalSigner, error) {
+	if pin == "" {
+		return nil, errors.New("aaa: the PIN for the PKCS#11 token is required")
+	}
+
+	ctx, err := crypto11.Configure(&crypto11.Config{
+		Path:       ref.Module,
+		TokenLabel: ref.TokenLabel,
+		Pin:        pin,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("opening the PKCS#11 token '%s': %w", ref.TokenLabel, err)
+	}
+
+	signer, err := findOrGeneratePKCS11Key(ctx, ref, create)
+	if err != nil {
+		ctx.Close()
+		return nil, err
+	}
+
+	return &pkcs11Signer{Signer: signer, ctx: ctx}, nil
+}
+
+func findOrGeneratePKCS11Key(ctx *crypto11.Context, ref *KeyRef, create bool) (crypto11.Signer, error) {
+	label := []byte(ref.Label)
+
+	signer, err := ctx.FindKeyPair(nil, label)
+	if err != nil {
+		return nil, fmt.Errorf("finding the key '%s': %w", ref.Label, err)
+	}
+
+	if signer != nil {
+		return signer, nil
+	}
+
+	if !create {
+		return nil, fmt.Errorf("aaa: the key '%s' is not found in the PKCS#11 token '%s'", ref.Label, ref.TokenLabel)
+	}
+
+	// the label is also used as the ID since the ID is required to generate the key
+	switch ref.KeyType {
+	case KeyTypeRSA2048:
+		return ctx.GenerateRSAKeyPairWithLabel(label, label, 2048)
+	case KeyTypeRSA4096:
+		return ctx.GenerateRSAKeyPairWithLabel(label, label, 4096)
+	case KeyTypeECDSAP256:
+		return ctx.GenerateECDSAKeyPairWithLabel(label, label, elliptic.P256())
+	case KeyTypeECDSAP384:
+		return ctx.GenerateECDSAKeyPairWithLabel(label, label, elliptic.P384())
+	default:
+		return nil, fmt.Errorf("aaa: unsupported key type '%s' for PKCS#11", ref.KeyType)
+	}
+}
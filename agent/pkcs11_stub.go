@@ -0,0 +1,10 @@
+//go:build !pkcs11
+
+package agent
+
+import "errors"
+
+// OpenPKCS11Key is not available since PKCS#11 requires cgo. Build with -tags pkcs11 to enable it.
+func OpenPKCS11Key(ref *KeyRef, pin string, create bool) (ExternalSigner, error) {
+	return nil, errors.New("aaa: PKCS#11 is not supported in this build. rebuild aaa with -tags pkcs11")
+}
@@ -3,12 +3,17 @@ package agent
 import (
 	"context"
 	"crypto"
+	"crypto/sha256"
 	"crypto/x509"
 	"encoding/json"
 	"encoding/pem"
 	"errors"
+	"fmt"
 	"os"
+	"reflect"
+	"slices"
 	"strconv"
+	"strings"
 
 	"github.com/go-acme/lego/v4/certcrypto"
 )
@@ -22,9 +27,15 @@ Per Store instance:
 {{email}}/info
 	- {{email}}.json -- the registration info
 
-{{email}}/domain/{{domain}}/
+{{email}}/index.json -- the index of all the certificates
+
+{{email}}/domain/{{domain}}/ -- *.example.com is stored in _wildcard.example.com
 	- privkey.pem   -- the private key in PEM
+	- keyref.json   -- the reference to the private key in KMS or PKCS#11 token instead of privkey.pem
 	- cert.pem      -- the cert
+	- csr.pem       -- the CSR for the cert issued without the private key
+	- metadata.json -- the issuance metadata
+	- config.json   -- the domain configuration
 */
 
 type Store struct {
@@ -51,6 +62,11 @@ func NewStore(email string, filer Filer) (*Store, error) {
 	return s, nil
 }
 
+// Email returns the email address of the account.
+func (s *Store) Email() string {
+	return s.email
+}
+
 // LoadRegistration returns the existing registration.
 func (s *Store) LoadRegistration(ctx context.Context) (*RegistrationInfo, error) {
 	blob, err := s.filer.ReadFile(ctx, s.joinPrefix("info", s.email+".json"))
@@ -76,15 +92,24 @@ func (s *Store) SaveRegistration(ctx context.Context, ri *RegistrationInfo) erro
 }
 
 func (s *Store) SaveCertKey(ctx context.Context, domain string, privKey crypto.PrivateKey) error {
-	return s.filer.WriteFile(
-		ctx,
-		s.joinPrefix("domain", domain, "privkey.pem"),
-		certcrypto.PEMEncode(privKey),
-	)
+	if err := s.writeDomainFile(ctx, domain, "privkey.pem", certcrypto.PEMEncode(privKey)); err != nil {
+		return err
+	}
+
+	return s.updateIndex(ctx, func(idx *Index) bool {
+		entry, ok := idx.Domains[domain]
+		if ok && entry.State != DomainStateOrphaned {
+			return false
+		}
+
+		idx.Domains[domain] = &IndexEntry{State: DomainStatePending}
+
+		return true
+	})
 }
 
 func (s *Store) LoadCertKey(ctx context.Context, domain string) (crypto.PrivateKey, error) {
-	blob, err := s.filer.ReadFile(ctx, s.joinPrefix("domain", domain, "privkey.pem"))
+	blob, err := s.LoadCertKeyPEM(ctx, domain)
 	if err != nil {
 		return nil, err
 	}
@@ -92,19 +117,143 @@ func (s *Store) LoadCertKey(ctx context.Context, domain string) (crypto.PrivateK
 	return certcrypto.ParsePEMPrivateKey(blob)
 }
 
+// LoadCertKeyPEM returns the private key for the cert in PEM.
+func (s *Store) LoadCertKeyPEM(ctx context.Context, domain string) ([]byte, error) {
+	return s.readDomainFile(ctx, domain, "privkey.pem")
+}
+
 func (s *Store) LoadCert(ctx context.Context, domain string) (*x509.Certificate, error) {
-	blob, err := s.filer.ReadFile(ctx, s.joinPrefix("domain", domain, "cert.pem"))
+	blob, err := s.LoadCertPEM(ctx, domain)
 	if err != nil {
 		return nil, err
 	}
 
 	block, _ := pem.Decode(blob)
+	if block == nil {
+		return nil, errors.New("aaa: no certificate in PEM")
+	}
 
 	return x509.ParseCertificate(block.Bytes)
 }
 
+// SaveCSR saves the externally generated CSR so that the cert can be renewed without the private key.
+func (s *Store) SaveCSR(ctx context.Context, domain string, csr []byte) error {
+	return s.writeDomainFile(ctx, domain, "csr.pem", csr)
+}
+
+// LoadCSR returns the CSR saved by SaveCSR.
+func (s *Store) LoadCSR(ctx context.Context, domain string) (*x509.CertificateRequest, error) {
+	blob, err := s.readDomainFile(ctx, domain, "csr.pem")
+	if err != nil {
+		return nil, err
+	}
+
+	return ParseCertificateRequest(blob)
+}
+
+// LoadCertPEM returns the cert in PEM. It may contain the issuer certificates if the cert is bundled.
+func (s *Store) LoadCertPEM(ctx context.Context, domain string) ([]byte, error) {
+	return s.readDomainFile(ctx, domain, "cert.pem")
+}
+
+// SaveCert saves the cert and updates the index of the account.
 func (s *Store) SaveCert(ctx context.Context, domain string, cert []byte) error {
-	return s.filer.WriteFile(ctx, s.joinPrefix("domain", domain, "cert.pem"), cert)
+	bundle, err := ParseCertBundle(cert)
+	if err != nil {
+		return err
+	}
+
+	if err := s.writeDomainFile(ctx, domain, "cert.pem", cert); err != nil {
+		return err
+	}
+
+	return s.updateIndex(ctx, func(idx *Index) bool {
+		entry := NewIndexEntry(bundle.Leaf)
+		if cur, ok := idx.Domains[domain]; ok {
+			entry.ACMCertificateARNs = cur.ACMCertificateARNs
+			entry.LastFailedAt = cur.LastFailedAt
+			entry.LastError = cur.LastError
+		}
+
+		idx.Domains[domain] = entry
+
+		return true
+	})
+}
+
+// LoadMetadata returns the issuance metadata for the domain.
+// If the metadata does not exist yet, it returns the empty metadata.
+func (s *Store) LoadMetadata(ctx context.Context, domain string) (*Metadata, error) {
+	md := &Metadata{}
+
+	blob, err := s.readDomainFile(ctx, domain, "metadata.json")
+	if err != nil {
+		if err == ErrFileNotFound {
+			return md, nil
+		}
+
+		return nil, err
+	}
+
+	if err := json.Unmarshal(blob, md); err != nil {
+		return nil, err
+	}
+
+	return md, nil
+}
+
+func (s *Store) SaveMetadata(ctx context.Context, domain string, md *Metadata) error {
+	blob, err := json.Marshal(md)
+	if err != nil {
+		return err
+	}
+
+	if err := s.writeDomainFile(ctx, domain, "metadata.json", blob); err != nil {
+		return err
+	}
+
+	return s.updateIndex(ctx, func(idx *Index) bool {
+		entry, ok := idx.Domains[domain]
+		if !ok {
+			entry = &IndexEntry{}
+			idx.Domains[domain] = entry
+		}
+
+		before := *entry
+		entry.apply(md)
+
+		return !ok || !reflect.DeepEqual(before, *entry)
+	})
+}
+
+// LoadDomainConfig returns the configuration for the domain.
+// If the configuration does not exist, it returns the empty configuration.
+func (s *Store) LoadDomainConfig(ctx context.Context, domain string) (*DomainConfig, error) {
+	cfg := &DomainConfig{}
+
+	blob, err := s.readDomainFile(ctx, domain, "config.json")
+	if err != nil {
+		if err == ErrFileNotFound {
+			return cfg, nil
+		}
+
+		return nil, err
+	}
+
+	if err := json.Unmarshal(blob, cfg); err != nil {
+		return nil, err
+	}
+
+	return cfg, nil
+}
+
+func (s *Store) SaveDomainConfig(ctx context.Context, domain string, cfg *DomainConfig) error {
+	blob, err := json.Marshal(cfg)
+	if err != nil {
+		return err
+	}
+
+	return s.writeDomainFile(ctx, domain, "config.json", blob)
 }
 
 func (s *Store) ListDomains(ctx context.Context) ([]string, error) {
@@ -118,15 +267,151 @@ func (s *Store) ListDomains(ctx context.Context) ([]string, error) {
 	for _, dir := range dirs {
 		elem := s.filer.Split(dir)
 
-		// domain is in 4th element
-		if len(elem) > 3 {
-			domains = append(domains, elem[3])
+		// domain is in the last element
+		dom := DecodeDomainPath(elem[len(elem)-1])
+
+		// the wildcard domain may be stored in both the legacy and the encoded paths
+		if dom != "" && !slices.Contains(domains, dom) {
+			domains = append(domains, dom)
 		}
 	}
 
 	return domains, nil
 }
 
+// domainFiles are all the files stored for the domain.
+var domainFiles = []string{"privkey.pem", "keyref.json", "cert.pem", "csr.pem", "metadata.json", "config.json"}
+
+// RemoveDomain removes all the files for the domain and its entry in the index
+// so that the domain is no longer renewed. The key kept in KMS or PKCS#11 token is not deleted.
+func (s *Store) RemoveDomain(ctx context.Context, domain string) error {
+	remover, ok := s.filer.(Remover)
+	if !ok {
+		return errors.New("aaa: the storage does not support removing the files")
+	}
+
+	for _, fn := range domainFiles {
+		if err := remover.RemoveFile(ctx, s.DomainPath(domain, fn)); err != nil {
+			return fmt.Errorf("removing %s: %w", fn, err)
+		}
+
+		if IsWildcard(domain) {
+			if err := remover.RemoveFile(ctx, s.legacyDomainPath(domain, fn)); err != nil {
+				return fmt.Errorf("removing %s: %w", fn, err)
+			}
+		}
+	}
+
+	// the files are removed first so that reindex never brings the entry back
+	return s.modifyIndex(ctx, func(idx *Index) bool {
+		if _, ok := idx.Domains[domain]; !ok {
+			return false
+		}
+
+		delete(idx.Domains, domain)
+
+		return true
+	})
+}
+
+// CertVersion returns the version of the certificate and the private key for the domain.
+// It falls back to the digest of the content if the filer does not implement Versioner.
+func (s *Store) CertVersion(ctx context.Context, domain string) (string, error) {
+	var versions []string
+	for _, fn := range []string{"cert.pem", "privkey.pem"} {
+		if v, ok := s.filer.(Versioner); ok {
+			version, err := v.Version(ctx, s.DomainPath(domain, fn))
+			if errors.Is(err, ErrFileNotFound) && IsWildcard(domain) {
+				version, err = v.Version(ctx, s.legacyDomainPath(domain, fn))
+			}
+
+			if err != nil {
+				return "", err
+			}
+
+			versions = append(versions, version)
+			continue
+		}
+
+		data, err := s.readDomainFile(ctx, domain, fn)
+		if err != nil {
+			return "", err
+		}
+
+		versions = append(versions, fmt.Sprintf("%x", sha256.Sum256(data)))
+	}
+
+	return strings.Join(versions, ":"), nil
+}
+
+// DomainPath returns the path to fn for the domain in the storage.
+func (s *Store) DomainPath(domain, fn string) string {
+	return s.joinPrefix("domain", EncodeDomainPath(domain), fn)
+}
+
+// legacyDomainPath returns the path to fn for the wildcard domain stored verbatim by the older versions.
+func (s *Store) legacyDomainPath(domain, fn string) string {
+	return s.joinPrefix("domain", domain, fn)
+}
+
+// readDomainFile reads fn for the domain. The wildcard domain is read from the legacy path
+// until it is migrated by writeDomainFile.
+func (s *Store) readDomainFile(ctx context.Context, domain, fn string) ([]byte, error) {
+	blob, err := s.filer.ReadFile(ctx, s.DomainPath(domain, fn))
+	if errors.Is(err, ErrFileNotFound) && IsWildcard(domain) {
+		return s.filer.ReadFile(ctx, s.legacyDomainPath(domain, fn))
+	}
+
+	return blob, err
+}
+
+// writeDomainFile writes fn for the domain in the encoded path. The wildcard domain stored in the legacy path
+// is migrated first so that all the files of the domain, including the private key, are kept in the same place.
+func (s *Store) writeDomainFile(ctx context.Context, domain, fn string, data []byte) error {
+	if IsWildcard(domain) {
+		if err := s.migrateLegacyDomain(ctx, domain); err != nil {
+			return fmt.Errorf("migrating '%s' from the legacy path: %w", domain, err)
+		}
+	}
+
+	return s.filer.WriteFile(ctx, s.DomainPath(domain, fn), data)
+}
+
+// migrateLegacyDomain copies the files of the wildcard domain from the legacy path into the encoded path
+// unless it has been written there, and removes them from the legacy path if the filer supports it.
+func (s *Store) migrateLegacyDomain(ctx context.Context, domain string) error {
+	remover, _ := s.filer.(Remover)
+
+	for _, fn := range domainFiles {
+		blob, err := s.filer.ReadFile(ctx, s.legacyDomainPath(domain, fn))
+		if errors.Is(err, ErrFileNotFound) {
+			continue
+		}
+
+		if err != nil {
+			return err
+		}
+
+		_, err = s.filer.ReadFile(ctx, s.DomainPath(domain, fn))
+		switch {
+		case errors.Is(err, ErrFileNotFound):
+			if err := s.filer.WriteFile(ctx, s.DomainPath(domain, fn), blob); err != nil {
+				return err
+			}
+		case err != nil:
+			return err
+		}
+
+		if remover != nil {
+			if err := remover.RemoveFile(ctx, s.legacyDomainPath(domain, fn)); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
 func (s *Store) joinPrefix(fns ...string) string {
 	return s.filer.Join(append([]string{s.prefix, s.email}, fns...)...)
 }
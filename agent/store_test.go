@@ -0,0 +1,156 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+)
+
+// newTestStore returns the store for you@example.com on filer.
+func newTestStore(t *testing.T, filer Filer) *Store {
+	t.Helper()
+
+	store, err := NewStore("you@example.com", filer)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return store
+}
+
+// newTestCertPEM returns the self-signed certificate for the domain in PEM.
+func newTestCertPEM(t *testing.T, domain string) []byte {
+	t.Helper()
+
+	return encodeCertsPEM(newTestCert(t, domain, nil, domain).Cert)
+}
+
+// failingRemover is OSFiler that fails to remove any file.
+type failingRemover struct {
+	*OSFiler
+}
+
+func (f *failingRemover) RemoveFile(context.Context, string) error {
+	return errors.New("boom")
+}
+
+func TestStoreRemoveDomain(t *testing.T) {
+	ctx := context.Background()
+
+	filer := &OSFiler{BaseDir: t.TempDir()}
+	store := newTestStore(t, filer)
+
+	for _, dom := range []string{"a.example.com", "b.example.com"} {
+		if err := store.SaveCert(ctx, dom, newTestCertPEM(t, dom)); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := store.SaveDomainConfig(ctx, dom, &DomainConfig{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// the files must be removed first so that reindex never brings the entry back
+	if err := newTestStore(t, &failingRemover{filer}).RemoveDomain(ctx, "a.example.com"); err == nil {
+		t.Fatal("RemoveDomain() with the failing filer must fail")
+	}
+
+	idx, err := store.LoadIndex(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok := idx.Domains["a.example.com"]; !ok {
+		t.Error("the entry must be kept if the files are not removed")
+	}
+
+	if err := store.RemoveDomain(ctx, "a.example.com"); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, fn := range domainFiles {
+		if _, err := filer.ReadFile(ctx, store.DomainPath("a.example.com", fn)); !errors.Is(err, ErrFileNotFound) {
+			t.Errorf("ReadFile(%s) = %v, want ErrFileNotFound", fn, err)
+		}
+	}
+
+	domains, err := store.ListDomains(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if want := []string{"b.example.com"}; !slices.Equal(domains, want) {
+		t.Errorf("ListDomains() = %v, want %v", domains, want)
+	}
+
+	idx, err = store.LoadIndex(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok := idx.Domains["a.example.com"]; ok {
+		t.Error("the entry must be removed from the index")
+	}
+
+	if _, ok := idx.Domains["b.example.com"]; !ok {
+		t.Error("the entry of the other domain must be kept")
+	}
+}
+
+func TestStoreMigrateLegacyDomain(t *testing.T) {
+	ctx := context.Background()
+
+	const domain = "*.example.com"
+
+	filer := &OSFiler{BaseDir: t.TempDir()}
+	store := newTestStore(t, filer)
+
+	// the files written by the older versions
+	legacy := map[string][]byte{
+		"privkey.pem":   []byte("privkey"),
+		"cert.pem":      newTestCertPEM(t, domain),
+		"metadata.json": []byte(`{"issued_count":1}`),
+		"config.json":   []byte(`{}`),
+	}
+
+	for fn, data := range legacy {
+		if err := filer.WriteFile(ctx, store.legacyDomainPath(domain, fn), data); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	renewed := newTestCertPEM(t, domain)
+	if err := store.SaveCert(ctx, domain, renewed); err != nil {
+		t.Fatal(err)
+	}
+
+	for fn, data := range legacy {
+		if fn == "cert.pem" {
+			data = renewed
+		}
+
+		got, err := filer.ReadFile(ctx, store.DomainPath(domain, fn))
+		if err != nil {
+			t.Errorf("ReadFile(%s) = %v", fn, err)
+			continue
+		}
+
+		if !slices.Equal(got, data) {
+			t.Errorf("%s = %q, want %q", fn, got, data)
+		}
+
+		if _, err := filer.ReadFile(ctx, store.legacyDomainPath(domain, fn)); !errors.Is(err, ErrFileNotFound) {
+			t.Errorf("ReadFile(legacy %s) = %v, want ErrFileNotFound", fn, err)
+		}
+	}
+
+	domains, err := store.ListDomains(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if want := []string{domain}; !slices.Equal(domains, want) {
+		t.Errorf("ListDomains() = %v, want %v", domains, want)
+	}
+}
@@ -5,6 +5,10 @@ import (
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/credentials/stscreds"
+	"github.com/aws/aws-sdk-go-v2/service/acm"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+	"github.com/nabeken/aaa/v3/agent"
 )
 
 func MustNewAWSConfig(ctx context.Context) aws.Config {
@@ -15,3 +19,26 @@ func MustNewAWSConfig(ctx context.Context) aws.Config {
 
 	return cfg
 }
+
+// MustNewAWSConfigForTarget returns aws.Config for the region and the account in the target.
+// If the target has a role, the config assumes it.
+func MustNewAWSConfigForTarget(ctx context.Context, target agent.ACMTarget) aws.Config {
+	cfg := MustNewAWSConfig(ctx)
+
+	if target.Region != "" {
+		cfg.Region = target.Region
+	}
+
+	if target.RoleARN != "" {
+		cfg.Credentials = aws.NewCredentialsCache(
+			stscreds.NewAssumeRoleProvider(sts.NewFromConfig(cfg), target.RoleARN),
+		)
+	}
+
+	return cfg
+}
+
+// NewACMClient returns ACM client for the target.
+func NewACMClient(ctx context.Context, target agent.ACMTarget) *acm.Client {
+	return acm.NewFromConfig(MustNewAWSConfigForTarget(ctx, target))
+}
@@ -2,11 +2,18 @@ package command
 
 import (
 	"context"
+	"crypto"
 	"crypto/rand"
 	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
 	"errors"
 	"fmt"
-	"log"
+	"log/slog"
+	"os"
+	"strings"
+	"time"
 
 	"github.com/go-acme/lego/v4/certificate"
 	"github.com/go-acme/lego/v4/providers/dns"
@@ -16,18 +23,34 @@ import (
 type CertCommand struct {
 	CommonName string   `long:"cn" description:"CommonName to be issued"`
 	Domains    []string `long:"domain" description:"Domains to be issued as Subject Alternative Names"`
+	CSR        string   `long:"csr" description:"Path to the CSR in PEM or DER. The certificate is issued for the domains in the CSR without storing the private key"`
 	CreateKey  bool     `long:"create-key" description:"Create a new keypair"`
 	RSAKeySize int      `long:"rsa-key-size" description:"Size of the RSA key, only used if create-key is specified. (allowed: 2048 / 4096)" default:"4096"`
 	BundleCA   bool     `long:"bundle-ca" description:"Bundle issuer CA certificate with the issued certificate"`
+	NoHooks    bool     `long:"no-hooks" description:"Do not run the hooks configured for the domain"`
+
+	PreferredChain string `long:"preferred-chain" description:"CommonName of the issuer in the alternate chain to be preferred"`
+	Profile        string `long:"profile" description:"ACME profile to be requested such as shortlived and tlsserver"`
+	MustStaple     bool   `long:"must-staple" description:"Request OCSP Must-Staple"`
+	NotAfter       string `long:"not-after" description:"Validity period to be requested such as 6d or 144h"`
+
+	KeyProvider      string `long:"key-provider" description:"Keep the new private key in AWS KMS or PKCS#11 token instead of the store" choice:"kms" choice:"pkcs11"`
+	KeyType          string `long:"key-type" description:"Type of the new key in --key-provider. RSA key in --rsa-key-size is created if not set. It must match the type of the existing key if given" choice:"RSA-2048" choice:"RSA-4096" choice:"ECDSA-P-256" choice:"ECDSA-P-384"`
+	KMSKeyID         string `long:"kms-key-id" description:"ARN of the existing asymmetric KMS key. A new key is created if not set"`
+	PKCS11Module     string `long:"pkcs11-module" description:"Path to the PKCS#11 module such as libsofthsm2.so"`
+	PKCS11TokenLabel string `long:"pkcs11-token-label" description:"Label of the PKCS#11 token"`
+	PKCS11KeyLabel   string `long:"pkcs11-key-label" description:"Label of the key in the PKCS#11 token. The CommonName is used if not set"`
 }
 
 func (c *CertCommand) Execute(args []string) error {
-	store, err := NewStore(Options.Email, Options.S3Bucket, Options.S3KMSKeyID)
+	ctx := context.Background()
+
+	store, err := NewStoreFromOptions(ctx)
 	if err != nil {
 		return fmt.Errorf("initializing the store: %w", err)
 	}
 
-	return (&CertService{
+	svc := &CertService{
 		Email:      Options.Email,
 		CommonName: c.CommonName,
 		Domains:    c.Domains,
@@ -35,7 +58,49 @@ func (c *CertCommand) Execute(args []string) error {
 		RSAKeySize: c.RSAKeySize,
 		BundleCA:   c.BundleCA,
 		Store:      store,
-	}).Run(context.Background())
+	}
+
+	if c.KeyProvider != "" {
+		svc.KeyRef = &agent.KeyRef{
+			Provider:   c.KeyProvider,
+			KeyType:    c.KeyType,
+			KeyID:      c.KMSKeyID,
+			Module:     c.PKCS11Module,
+			TokenLabel: c.PKCS11TokenLabel,
+			Label:      c.PKCS11KeyLabel,
+		}
+	}
+
+	// the options replace the stored ones as a whole so that they are kept for the renewals
+	if c.PreferredChain != "" || c.Profile != "" || c.MustStaple || c.NotAfter != "" {
+		svc.Issuance = &agent.IssuanceConfig{
+			PreferredChain: c.PreferredChain,
+			Profile:        c.Profile,
+			MustStaple:     c.MustStaple,
+			NotAfter:       c.NotAfter,
+		}
+	}
+
+	if c.CSR != "" {
+		if svc.CSR, err = os.ReadFile(c.CSR); err != nil {
+			return fmt.Errorf("reading the CSR: %w", err)
+		}
+	}
+
+	if err := svc.Run(ctx); err != nil {
+		return err
+	}
+
+	if c.NoHooks {
+		return nil
+	}
+
+	// the CommonName has been normalized by the service
+	if err := (&HookRunner{Store: store}).Run(ctx, svc.CommonName); err != nil {
+		return fmt.Errorf("running the hooks: %w", err)
+	}
+
+	return nil
 }
 
 type CertService struct {
@@ -46,10 +111,138 @@ type CertService struct {
 	RSAKeySize int
 	BundleCA   bool
 	Store      *agent.Store
+
+	// CSR is the externally generated CSR in PEM or DER. If set, CommonName and Domains are taken from the CSR
+	// and the certificate is issued without the private key. The CSR is saved for the renewal.
+	CSR []byte
+
+	// KeyRef is the provider of the new private key kept outside of the store.
+	// The key referenced in the store is always used on the renewal.
+	KeyRef *agent.KeyRef
+
+	// Issuance replaces the issuance options stored for the domain after the successful issuance.
+	// The stored options are used if nil, e.g. on the renewal.
+	Issuance *agent.IssuanceConfig
+
+	csr      *x509.CertificateRequest
+	issuance agent.IssuanceConfig
 }
 
+// Run issues the certificate and records the result into the metadata.
 func (svc *CertService) Run(ctx context.Context) error {
-	log.Print("INFO: now issuing certificate...")
+	if err := svc.prepareDomains(); err != nil {
+		return err
+	}
+
+	if err := svc.validateIssuance(); err != nil {
+		return err
+	}
+
+	logger := slog.Default().With("email", svc.Store.Email(), "domain", svc.CommonName)
+
+	err := svc.run(ctx, logger)
+
+	if merr := svc.recordResult(ctx, err); merr != nil {
+		logger.WarnContext(ctx, "failed to record the issuance metadata", "error", merr)
+	}
+
+	return err
+}
+
+// prepareDomains normalizes the domains into A-labels and rejects the invalid ones
+// before anything is recorded under the domain. The domains are taken from the CSR if given.
+func (svc *CertService) prepareDomains() error {
+	if svc.CSR != nil {
+		csr, err := agent.ParseCertificateRequest(svc.CSR)
+		if err != nil {
+			return err
+		}
+
+		if svc.CreateKey {
+			return errors.New("--create-key can't be used with --csr")
+		}
+
+		if len(svc.Domains) > 0 {
+			return errors.New("--domain can't be used with --csr. the domains are taken from the CSR")
+		}
+
+		domains := agent.CertificateRequestDomains(csr)
+		if len(domains) == 0 {
+			return errors.New("aaa: the CSR has no domain")
+		}
+
+		if svc.CommonName != "" && svc.CommonName != domains[0] {
+			return fmt.Errorf("--cn '%s' does not match the CommonName '%s' in the CSR", svc.CommonName, domains[0])
+		}
+
+		// the CSR can't be rewritten so that the domains must be in A-labels already
+		for _, domain := range domains {
+			normalized, err := agent.NormalizeDomain(domain)
+			if err != nil {
+				return err
+			}
+
+			if normalized != domain {
+				return fmt.Errorf("aaa: '%s' in the CSR must be '%s'", domain, normalized)
+			}
+		}
+
+		svc.csr = csr
+		svc.CommonName = domains[0]
+		svc.Domains = domains[1:]
+
+		return nil
+	}
+
+	cn, err := agent.NormalizeDomain(svc.CommonName)
+	if err != nil {
+		return err
+	}
+
+	svc.CommonName = cn
+
+	for i, domain := range svc.Domains {
+		if svc.Domains[i], err = agent.NormalizeDomain(domain); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (svc *CertService) validateIssuance() error {
+	if svc.Issuance == nil {
+		return nil
+	}
+
+	if svc.CSR != nil && svc.Issuance.MustStaple {
+		return errors.New("--must-staple can't be used with --csr. request it in the CSR instead")
+	}
+
+	if _, err := svc.Issuance.NotAfterTime(time.Now()); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (svc *CertService) recordResult(ctx context.Context, err error) error {
+	md, merr := svc.Store.LoadMetadata(ctx, svc.CommonName)
+	if merr != nil {
+		return merr
+	}
+
+	if err != nil {
+		md.RecordFailure(time.Now(), err)
+	} else {
+		md.RecordSuccess(time.Now())
+	}
+
+	return svc.Store.SaveMetadata(ctx, svc.CommonName, md)
+}
+
+func (svc *CertService) run(ctx context.Context, logger *slog.Logger) error {
+	logger.InfoContext(ctx, "now issuing certificate", "step", "start")
 
 	ri, err := svc.Store.LoadRegistration(ctx)
 	if err != nil {
@@ -61,37 +254,24 @@ func (svc *CertService) Run(ctx context.Context) error {
 		return err
 	}
 
-	// trying to load the key
-	key, err := svc.Store.LoadCertKey(ctx, svc.CommonName)
+	cfg, err := svc.Store.LoadDomainConfig(ctx, svc.CommonName)
 	if err != nil {
-		if err != agent.ErrFileNotFound {
-			return fmt.Errorf("loading the key: %w", err)
-		}
-
-		// we have to create a new keypair anyway
-		svc.CreateKey = true
+		return fmt.Errorf("loading the config: %w", err)
 	}
 
-	// Creating private key for cert
-	if svc.CreateKey {
-		if svc.RSAKeySize != 4096 && svc.RSAKeySize != 2048 {
-			return errors.New("key size must be 4096 or 2048")
-		}
-
-		log.Printf("INFO: creating %d bit new private key...", svc.RSAKeySize)
-		certPrivkey, err := rsa.GenerateKey(rand.Reader, svc.RSAKeySize)
-		if err != nil {
-			return fmt.Errorf("generating a keypair: %w", err)
-		}
+	svc.issuance = cfg.Issuance
+	if svc.Issuance != nil {
+		svc.issuance = *svc.Issuance
+	}
 
-		// storing private key for certificate
-		if err := svc.Store.SaveCertKey(ctx, svc.CommonName, certPrivkey); err != nil {
-			return fmt.Errorf("storing the private key for the cert: %w", err)
-		}
+	notAfter, err := svc.issuance.NotAfterTime(time.Now())
+	if err != nil {
+		return err
+	}
 
-		key = certPrivkey
-	} else {
-		log.Print("INFO: using the existing private key...")
+	key, err := svc.loadKey(ctx, logger)
+	if err != nil {
+		return err
 	}
 
 	provider, err := dns.NewDNSChallengeProviderByName("route53")
@@ -103,22 +283,273 @@ func (svc *CertService) Run(ctx context.Context) error {
 		return fmt.Errorf("setting the DNS provider: %w", err)
 	}
 
-	request := certificate.ObtainRequest{
-		Domains:    append([]string{svc.CommonName}, svc.Domains...),
-		PrivateKey: key,
-		Bundle:     svc.BundleCA,
+	logger.InfoContext(ctx, "obtaining the certificate", "step", "obtain", "san", svc.Domains, "csr", svc.csr != nil,
+		"profile", svc.issuance.Profile, "preferred_chain", svc.issuance.PreferredChain, "not_after", notAfter)
+
+	var cert *certificate.Resource
+	if svc.csr != nil {
+		// Must-Staple is in the CSR
+		cert, err = client.Certificate.ObtainForCSR(certificate.ObtainForCSRRequest{
+			CSR:            svc.csr,
+			NotAfter:       notAfter,
+			Bundle:         svc.BundleCA,
+			PreferredChain: svc.issuance.PreferredChain,
+			Profile:        svc.issuance.Profile,
+		})
+	} else {
+		cert, err = client.Certificate.Obtain(certificate.ObtainRequest{
+			Domains:        append([]string{svc.CommonName}, svc.Domains...),
+			PrivateKey:     key,
+			MustStaple:     svc.issuance.MustStaple,
+			NotAfter:       notAfter,
+			Bundle:         svc.BundleCA,
+			PreferredChain: svc.issuance.PreferredChain,
+			Profile:        svc.issuance.Profile,
+		})
 	}
 
-	cert, err := client.Certificate.Obtain(request)
 	if err != nil {
 		return fmt.Errorf("obtaining the certificate: %w", err)
 	}
 
+	if svc.CSR != nil {
+		if err := svc.Store.SaveCSR(ctx, svc.CommonName, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: svc.csr.Raw})); err != nil {
+			return fmt.Errorf("storing the CSR: %w", err)
+		}
+	}
+
 	if err := svc.Store.SaveCert(ctx, svc.CommonName, cert.Certificate); err != nil {
 		return fmt.Errorf("storing the certificate: %w", err)
 	}
 
-	log.Print("INFO: certificate is successfully saved")
+	logger.InfoContext(ctx, "certificate is successfully saved", "step", "save", "cert_url", cert.CertURL)
+
+	if svc.Issuance != nil && *svc.Issuance != cfg.Issuance {
+		cfg.Issuance = *svc.Issuance
+		if err := svc.Store.SaveDomainConfig(ctx, svc.CommonName, cfg); err != nil {
+			return fmt.Errorf("storing the issuance options: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// loadKey returns the private key for the certificate. It creates a new keypair if there is no key
+// or CreateKey is set. It returns nil if the certificate is issued for the CSR, including the renewal
+// of the certificate that was issued for the CSR without the private key.
+func (svc *CertService) loadKey(ctx context.Context, logger *slog.Logger) (crypto.PrivateKey, error) {
+	if svc.csr != nil {
+		// the stored key would be used on the renewal so that it must be the key for the CSR, e.g. generated by `aaa csr`
+		key, err := svc.Store.LoadCertKey(ctx, svc.CommonName)
+		switch {
+		case err == agent.ErrFileNotFound:
+		case err != nil:
+			return nil, fmt.Errorf("loading the key: %w", err)
+		case !publicKeyEqual(key, svc.csr.PublicKey):
+			return nil, errors.New("aaa: the domain has the private key that does not match the CSR")
+		}
+
+		return nil, svc.checkExternalKeyForCSR(ctx)
+	}
+
+	ref, err := svc.Store.LoadKeyRef(ctx, svc.CommonName)
+	if err != nil && err != agent.ErrFileNotFound {
+		return nil, fmt.Errorf("loading the key reference: %w", err)
+	}
+
+	// the key kept outside of the store is issued for the CSR signed by the key
+	switch {
+	case ref != nil && !svc.CreateKey:
+		if err := svc.checkKeyRef(ref); err != nil {
+			return nil, err
+		}
+
+		var keyType string
+		if svc.KeyRef != nil {
+			keyType = svc.KeyRef.KeyType
+		}
+
+		logger.InfoContext(ctx, "using the existing external key", "step", "load-key", "key", ref.String())
+
+		stored := ref.KeyType
+		if err := svc.signCSR(ctx, ref, keyType, false); err != nil {
+			return nil, err
+		}
+
+		// correcting the type recorded by the older versions so that the rotation creates the key in the same type
+		if ref.KeyType != stored {
+			if err := svc.Store.SaveKeyRef(ctx, svc.CommonName, ref); err != nil {
+				return nil, fmt.Errorf("storing the key reference for the cert: %w", err)
+			}
+		}
+
+		return nil, nil
+	case svc.KeyRef != nil:
+		return nil, svc.createExternalKey(ctx, logger, svc.KeyRef)
+	case ref != nil:
+		// rotating the key in the same provider
+		next := *ref
+		next.KeyID = ""
+		next.Label = fmt.Sprintf("%s-%d", svc.CommonName, time.Now().Unix())
+
+		return nil, svc.createExternalKey(ctx, logger, &next)
+	}
+
+	if !svc.CreateKey {
+		// trying to load the key
+		key, err := svc.Store.LoadCertKey(ctx, svc.CommonName)
+		if err == nil {
+			logger.InfoContext(ctx, "using the existing private key", "step", "load-key")
+			return key, nil
+		}
+
+		if err != agent.ErrFileNotFound {
+			return nil, fmt.Errorf("loading the key: %w", err)
+		}
+
+		csr, err := svc.Store.LoadCSR(ctx, svc.CommonName)
+		switch {
+		case err == nil:
+			logger.InfoContext(ctx, "using the saved CSR", "step", "load-csr")
+			svc.csr = csr
+
+			return nil, nil
+		case err != agent.ErrFileNotFound:
+			return nil, fmt.Errorf("loading the CSR: %w", err)
+		}
+
+		// we have to create a new keypair anyway
+	}
+
+	if svc.RSAKeySize != 4096 && svc.RSAKeySize != 2048 {
+		return nil, errors.New("key size must be 4096 or 2048")
+	}
+
+	logger.InfoContext(ctx, "creating new private key", "step", "create-key", "rsa_key_size", svc.RSAKeySize)
+	certPrivkey, err := rsa.GenerateKey(rand.Reader, svc.RSAKeySize)
+	if err != nil {
+		return nil, fmt.Errorf("generating a keypair: %w", err)
+	}
+
+	// storing private key for certificate
+	if err := svc.Store.SaveCertKey(ctx, svc.CommonName, certPrivkey); err != nil {
+		return nil, fmt.Errorf("storing the private key for the cert: %w", err)
+	}
+
+	return certPrivkey, nil
+}
+
+// checkKeyRef ensures that the key requested by KeyRef is the key referenced in the store
+// since the referenced key is used unless CreateKey is set.
+func (svc *CertService) checkKeyRef(ref *agent.KeyRef) error {
+	want := svc.KeyRef
+	if want == nil {
+		return nil
+	}
+
+	// the key ID may be given without the ARN
+	keyIDMatch := want.KeyID == "" || want.KeyID == ref.KeyID || strings.HasSuffix(ref.KeyID, "/"+want.KeyID)
+
+	if want.Provider != ref.Provider || !keyIDMatch ||
+		(want.Module != "" && want.Module != ref.Module) ||
+		(want.TokenLabel != "" && want.TokenLabel != ref.TokenLabel) ||
+		(want.Label != "" && want.Label != ref.Label) {
+		return fmt.Errorf("aaa: the domain already uses the key %s. pass --create-key to switch to another key", ref)
+	}
+
+	return nil
+}
+
+func (svc *CertService) createExternalKey(ctx context.Context, logger *slog.Logger, ref *agent.KeyRef) error {
+	if ref.Provider == agent.KeyProviderPKCS11 && ref.Label == "" {
+		ref.Label = svc.CommonName
+	}
+
+	// the type of the existing key such as --kms-key-id is taken from the key itself
+	keyType := ref.KeyType
+	if ref.KeyType == "" {
+		ref.KeyType = fmt.Sprintf("RSA-%d", svc.RSAKeySize)
+	}
+
+	if err := ref.Validate(); err != nil {
+		return err
+	}
+
+	logger.InfoContext(ctx, "creating new external key", "step", "create-key", "provider", ref.Provider, "key_type", ref.KeyType)
+
+	if err := svc.signCSR(ctx, ref, keyType, true); err != nil {
+		return err
+	}
+
+	if err := svc.Store.SaveKeyRef(ctx, svc.CommonName, ref); err != nil {
+		return fmt.Errorf("storing the key reference for the cert: %w", err)
+	}
+
+	return nil
+}
+
+// signCSR builds the CSR for the domains signed by the external key and updates ref.KeyType to the type of the key.
+// keyType is the type requested by --key-type. It must match the key if not empty.
+func (svc *CertService) signCSR(ctx context.Context, ref *agent.KeyRef, keyType string, create bool) error {
+	signer, err := openExternalKey(ctx, ref, "aaa: the private key for "+svc.CommonName, create)
+	if err != nil {
+		return err
+	}
+
+	defer signer.Close()
+
+	actual := agent.PublicKeyType(signer.Public())
+	switch {
+	case actual == "":
+		return fmt.Errorf("aaa: the key %s is not supported", ref)
+	case keyType != "" && keyType != actual:
+		return fmt.Errorf("aaa: the key %s is %s but --key-type is %s", ref, actual, keyType)
+	}
+
+	ref.KeyType = actual
+
+	blob, err := agent.CreateCertificateRequest(signer, pkix.Name{}, svc.issuance.MustStaple, append([]string{svc.CommonName}, svc.Domains...)...)
+	if err != nil {
+		return fmt.Errorf("creating the CSR: %w", err)
+	}
+
+	svc.csr, err = agent.ParseCertificateRequest(blob)
+
+	return err
+}
+
+// checkExternalKeyForCSR ensures that the external key referenced in the store is the key for the CSR
+// since it is used on the renewal.
+func (svc *CertService) checkExternalKeyForCSR(ctx context.Context) error {
+	ref, err := svc.Store.LoadKeyRef(ctx, svc.CommonName)
+	switch {
+	case err == agent.ErrFileNotFound:
+		return nil
+	case err != nil:
+		return fmt.Errorf("loading the key reference: %w", err)
+	}
+
+	signer, err := openExternalKey(ctx, ref, "", false)
+	if err != nil {
+		return err
+	}
+
+	defer signer.Close()
+
+	if !publicKeyEqual(signer, svc.csr.PublicKey) {
+		return fmt.Errorf("aaa: the domain has the key '%s' that does not match the CSR", ref)
+	}
 
 	return nil
 }
+
+func publicKeyEqual(key crypto.PrivateKey, pub crypto.PublicKey) bool {
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return false
+	}
+
+	k, ok := signer.Public().(interface{ Equal(crypto.PublicKey) bool })
+
+	return ok && k.Equal(pub)
+}
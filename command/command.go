@@ -2,6 +2,8 @@ package command
 
 import (
 	"context"
+	"errors"
+	"os"
 
 	"github.com/aws/aws-sdk-go-v2/service/s3"
 	"github.com/nabeken/aaa/v3/agent"
@@ -10,9 +12,61 @@ import (
 
 // Options for the global command.
 var Options struct {
-	S3Bucket   string `long:"s3-bucket" description:"S3 Bucket Name" required:"true"`
+	Storage    string `long:"storage" description:"Storage backend" choice:"s3" choice:"vault" default:"s3"`
+	S3Bucket   string `long:"s3-bucket" description:"S3 Bucket Name"`
 	S3KMSKeyID string `long:"s3-kms-key" description:"KMS Key ID for S3 SSE-KMS"`
 	Email      string `long:"email" description:"Email Address"`
+
+	LogFormat string `long:"log-format" description:"Format of the log output" choice:"text" choice:"json" default:"text"`
+	LogLevel  string `long:"log-level" description:"Log level" choice:"debug" choice:"info" choice:"warn" choice:"error" default:"info"`
+
+	VaultMount        string `long:"vault-mount" description:"Mount path of Vault KV v2 secrets engine" default:"secret"`
+	VaultRoleID       string `long:"vault-role-id" description:"AppRole role ID for Vault (VAULT_TOKEN is used if not set)" env:"VAULT_ROLE_ID"`
+	VaultSecretID     string `long:"vault-secret-id" description:"AppRole secret ID for Vault" env:"VAULT_SECRET_ID"`
+	VaultAppRoleMount string `long:"vault-approle-mount" description:"Mount path of AppRole auth method" default:"approle"`
+}
+
+// SetupLogger sets the default logger with the log options in the global options.
+func SetupLogger() error {
+	logger, err := agent.NewLogger(os.Stderr, Options.LogFormat, Options.LogLevel)
+	if err != nil {
+		return err
+	}
+
+	agent.SetDefaultLogger(logger)
+
+	return nil
+}
+
+// NewFiler initializes agent.Filer for cli apps with the storage backend in the global options.
+func NewFiler(ctx context.Context) (agent.Filer, error) {
+	switch Options.Storage {
+	case "vault":
+		client, err := NewVaultClient(ctx, Options.VaultRoleID, Options.VaultSecretID, Options.VaultAppRoleMount)
+		if err != nil {
+			return nil, err
+		}
+
+		return agent.NewVaultFiler(client, Options.VaultMount), nil
+	default:
+		if Options.S3Bucket == "" {
+			return nil, errors.New("aaa: --s3-bucket is required")
+		}
+
+		s3b := bucket.New(s3.NewFromConfig(MustNewAWSConfig(ctx)), Options.S3Bucket)
+
+		return agent.NewS3Filer(s3b, Options.S3KMSKeyID), nil
+	}
+}
+
+// NewStoreFromOptions initializes agent.Store for cli apps with the global options.
+func NewStoreFromOptions(ctx context.Context) (*agent.Store, error) {
+	filer, err := NewFiler(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return agent.NewStore(Options.Email, filer)
 }
 
 // NewStore initializes agent.Store for cli apps.
@@ -0,0 +1,77 @@
+package command
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+
+	"github.com/nabeken/aaa/v3/agent"
+)
+
+type ConfigCommand struct {
+	Domain string `long:"domain" description:"Domain to be configured" required:"true"`
+	File   string `long:"file" description:"Path to the configuration in JSON to be saved ('-' for stdin). The current configuration is printed if not set"`
+}
+
+func (c *ConfigCommand) Execute(args []string) error {
+	ctx := context.Background()
+
+	domain, err := agent.NormalizeDomain(c.Domain)
+	if err != nil {
+		return err
+	}
+
+	c.Domain = domain
+
+	store, err := NewStoreFromOptions(ctx)
+	if err != nil {
+		return fmt.Errorf("initializing the store: %w", err)
+	}
+
+	if c.File == "" {
+		cfg, err := store.LoadDomainConfig(ctx, c.Domain)
+		if err != nil {
+			return fmt.Errorf("loading the domain config: %w", err)
+		}
+
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+
+		return enc.Encode(cfg)
+	}
+
+	var r io.Reader = os.Stdin
+	if c.File != "-" {
+		f, err := os.Open(c.File)
+		if err != nil {
+			return err
+		}
+
+		defer f.Close()
+
+		r = f
+	}
+
+	dec := json.NewDecoder(r)
+	dec.DisallowUnknownFields()
+
+	cfg := &agent.DomainConfig{}
+	if err := dec.Decode(cfg); err != nil {
+		return fmt.Errorf("parsing the domain config: %w", err)
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return fmt.Errorf("validating the domain config: %w", err)
+	}
+
+	if err := store.SaveDomainConfig(ctx, c.Domain, cfg); err != nil {
+		return fmt.Errorf("saving the domain config: %w", err)
+	}
+
+	slog.InfoContext(ctx, "domain config has been saved", "email", store.Email(), "domain", c.Domain)
+
+	return nil
+}
@@ -0,0 +1,121 @@
+package command
+
+import (
+	"context"
+	"crypto"
+	"crypto/x509/pkix"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/nabeken/aaa/v3/agent"
+)
+
+type CSRCommand struct {
+	CommonName string   `long:"cn" description:"Domain whose private key signs the CSR. It is used as CommonName" required:"true"`
+	Domains    []string `long:"domain" description:"Domains to be requested as Subject Alternative Names"`
+	Out        string   `long:"out" description:"File to write the CSR in PEM ('-' for stdout)" default:"-"`
+	MustStaple bool     `long:"must-staple" description:"Request OCSP Must-Staple in the CSR"`
+
+	Organization       []string `long:"organization" description:"Organization (O) in the subject"`
+	OrganizationalUnit []string `long:"organizational-unit" description:"Organizational unit (OU) in the subject"`
+	Country            []string `long:"country" description:"Country (C) in the subject"`
+	Province           []string `long:"province" description:"State or province (ST) in the subject"`
+	Locality           []string `long:"locality" description:"Locality (L) in the subject"`
+}
+
+func (c *CSRCommand) Execute(args []string) error {
+	ctx := context.Background()
+
+	store, err := NewStoreFromOptions(ctx)
+	if err != nil {
+		return fmt.Errorf("initializing the store: %w", err)
+	}
+
+	csr, err := (&CSRService{
+		CommonName: c.CommonName,
+		Domains:    c.Domains,
+		Subject: pkix.Name{
+			Organization:       c.Organization,
+			OrganizationalUnit: c.OrganizationalUnit,
+			Country:            c.Country,
+			Province:           c.Province,
+			Locality:           c.Locality,
+		},
+		MustStaple: c.MustStaple,
+		Store:      store,
+	}).Run(ctx)
+	if err != nil {
+		return err
+	}
+
+	if c.Out == "-" {
+		_, err := os.Stdout.Write(csr)
+		return err
+	}
+
+	return os.WriteFile(c.Out, csr, 0644)
+}
+
+// CSRService generates CSR signed by the private key in the store.
+type CSRService struct {
+	CommonName string
+	Domains    []string
+
+	// Subject is the additional subject fields. CommonName is always set to the CommonName of the service.
+	Subject pkix.Name
+
+	MustStaple bool
+
+	Store *agent.Store
+}
+
+// Run returns the CSR in PEM.
+func (svc *CSRService) Run(ctx context.Context) ([]byte, error) {
+	domains := append([]string{svc.CommonName}, svc.Domains...)
+	for i, domain := range domains {
+		normalized, err := agent.NormalizeDomain(domain)
+		if err != nil {
+			return nil, err
+		}
+
+		domains[i] = normalized
+	}
+
+	signer, err := svc.loadSigner(ctx, domains[0])
+	if err != nil {
+		return nil, err
+	}
+
+	if closer, ok := signer.(agent.ExternalSigner); ok {
+		defer closer.Close()
+	}
+
+	subject := svc.Subject
+	subject.CommonName = domains[0]
+
+	return agent.CreateCertificateRequest(signer, subject, svc.MustStaple, domains...)
+}
+
+// loadSigner returns the key kept outside of the store if referenced, or the private key in the store.
+func (svc *CSRService) loadSigner(ctx context.Context, domain string) (crypto.Signer, error) {
+	ref, err := svc.Store.LoadKeyRef(ctx, domain)
+	switch {
+	case err == nil:
+		return openExternalKey(ctx, ref, "", false)
+	case err != agent.ErrFileNotFound:
+		return nil, fmt.Errorf("loading the key reference: %w", err)
+	}
+
+	key, err := svc.Store.LoadCertKey(ctx, domain)
+	if err != nil {
+		return nil, fmt.Errorf("loading the private key: %w", err)
+	}
+
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, errors.New("aaa: unsupported private key")
+	}
+
+	return signer, nil
+}
@@ -0,0 +1,202 @@
+package command
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math/rand/v2"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/nabeken/aaa/v3/agent"
+)
+
+type DaemonCommand struct {
+	Interval    time.Duration `long:"interval" description:"Interval to check the renewal" default:"12h"`
+	RenewalDays int           `long:"renewal-days" description:"Renew certificates expiring within the days" default:"30"`
+	Workers     int           `long:"workers" description:"Number of certificates to be renewed concurrently" default:"2"`
+	Jitter      time.Duration `long:"jitter" description:"Maximum random delay before each renewal" default:"1m"`
+	MaxRetries  int           `long:"max-retries" description:"Number of retries on renewal failure" default:"3"`
+	Backoff     time.Duration `long:"backoff" description:"Initial backoff between retries. It doubles on each retry" default:"1m"`
+	PostHooks   []string      `long:"post-hook" description:"Command to be executed by sh after renewal in addition to the hooks configured for the domain"`
+}
+
+func (c *DaemonCommand) Execute(args []string) error {
+	if c.Interval <= 0 {
+		return errors.New("--interval must be positive")
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	filer, err := NewFiler(ctx)
+	if err != nil {
+		return err
+	}
+
+	postHooks := make([]agent.HookConfig, len(c.PostHooks))
+	for i, hook := range c.PostHooks {
+		postHooks[i] = agent.HookConfig{Type: agent.HookTypeExec, Command: hook}
+	}
+
+	return (&DaemonService{
+		Filer:       filer,
+		Interval:    c.Interval,
+		RenewalDays: c.RenewalDays,
+		Workers:     c.Workers,
+		Jitter:      c.Jitter,
+		MaxRetries:  c.MaxRetries,
+		Backoff:     c.Backoff,
+		PostHooks:   postHooks,
+	}).Run(ctx)
+}
+
+// DaemonService checks the renewal on the interval and renews the certificates in-process.
+type DaemonService struct {
+	Filer       agent.Filer
+	Interval    time.Duration
+	RenewalDays int
+	Workers     int
+	Jitter      time.Duration
+	MaxRetries  int
+	Backoff     time.Duration
+
+	// PostHooks are executed after renewal in addition to the hooks configured for the domain.
+	PostHooks []agent.HookConfig
+}
+
+// Run runs the renewal check until ctx is canceled.
+func (svc *DaemonService) Run(ctx context.Context) error {
+	slog.InfoContext(ctx, "starting the daemon", "interval", svc.Interval, "workers", svc.Workers)
+
+	ticker := time.NewTicker(svc.Interval)
+	defer ticker.Stop()
+
+	for {
+		if err := svc.RunOnce(ctx); err != nil {
+			slog.ErrorContext(ctx, "failed to check the renewal", "error", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			slog.InfoContext(ctx, "shutting down the daemon")
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// RunOnce renews the certificates that require renewal with the bounded worker pool.
+func (svc *DaemonService) RunOnce(ctx context.Context) error {
+	domains, err := (&LsService{Filer: svc.Filer}).FetchData(ctx)
+	if err != nil {
+		return fmt.Errorf("listing all the domains: %w", err)
+	}
+
+	targets := RenewalTargets(domains, time.Now(), svc.RenewalDays)
+
+	slog.InfoContext(ctx, "checked renewal", "targets", len(targets))
+
+	queue := make(chan Domain)
+
+	var wg sync.WaitGroup
+	for range max(svc.Workers, 1) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			for dom := range queue {
+				svc.renew(ctx, dom)
+			}
+		}()
+	}
+
+	for _, dom := range targets {
+		select {
+		case queue <- dom:
+		case <-ctx.Done():
+		}
+	}
+
+	close(queue)
+	wg.Wait()
+
+	return ctx.Err()
+}
+
+func (svc *DaemonService) renew(ctx context.Context, dom Domain) {
+	logger := slog.Default().With("email", dom.Email, "domain", dom.Domain)
+
+	if !sleepContext(ctx, randDuration(svc.Jitter)) {
+		return
+	}
+
+	store, err := agent.NewStore(dom.Email, svc.Filer)
+	if err != nil {
+		logger.ErrorContext(ctx, "failed to initialize the store", "error", err)
+		return
+	}
+
+	certSvc := &CertService{
+		Email:      dom.Email,
+		CommonName: dom.Domain,
+		Domains:    dom.SANWithoutCommonName(),
+		RSAKeySize: 4096,
+		Store:      store,
+	}
+
+	backoff := svc.Backoff
+	for attempt := 0; ; attempt++ {
+		err = certSvc.Run(ctx)
+		if err == nil {
+			break
+		}
+
+		if attempt >= svc.MaxRetries {
+			logger.ErrorContext(ctx, "giving up the renewal", "attempts", attempt+1, "error", err)
+			return
+		}
+
+		logger.WarnContext(ctx, "failed to renew. retrying...", "attempt", attempt+1, "backoff", backoff, "error", err)
+
+		if !sleepContext(ctx, backoff+randDuration(svc.Jitter)) {
+			return
+		}
+
+		backoff *= 2
+	}
+
+	hookRunner := &HookRunner{
+		Store:      store,
+		ExtraHooks: svc.PostHooks,
+	}
+
+	if err := hookRunner.Run(ctx, dom.Domain); err != nil {
+		logger.ErrorContext(ctx, "failed to run the hooks", "error", err)
+	}
+}
+
+func randDuration(d time.Duration) time.Duration {
+	if d <= 0 {
+		return 0
+	}
+
+	return rand.N(d)
+}
+
+// sleepContext sleeps for d. It returns false if ctx is canceled before d elapses.
+func sleepContext(ctx context.Context, d time.Duration) bool {
+	t := time.NewTimer(d)
+	defer t.Stop()
+
+	select {
+	case <-ctx.Done():
+		return false
+	case <-t.C:
+		return true
+	}
+}
@@ -0,0 +1,149 @@
+package command
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/nabeken/aaa/v3/agent"
+)
+
+type ExportCommand struct {
+	Domain       string `long:"domain" description:"Domain to be exported" required:"true"`
+	Format       string `long:"format" description:"Format of the files" choice:"pem" choice:"der" choice:"pfx" choice:"jks" default:"pem"`
+	PasswordFile string `long:"password-file" description:"File containing the password for pfx and jks formats"`
+	Alias        string `long:"alias" description:"Alias of the private key entry in jks format. The domain is used if not set"`
+	Out          string `long:"out" description:"Directory to write the files" default:"."`
+	Mode         string `long:"mode" description:"Permission of the files in octal" default:"0600"`
+}
+
+func (c *ExportCommand) Execute(args []string) error {
+	ctx := context.Background()
+
+	domain, err := agent.NormalizeDomain(c.Domain)
+	if err != nil {
+		return err
+	}
+
+	c.Domain = domain
+
+	mode, err := strconv.ParseUint(c.Mode, 8, 32)
+	if err != nil {
+		return fmt.Errorf("parsing --mode: %w", err)
+	}
+
+	password, err := readPassword(c.Format, c.PasswordFile)
+	if err != nil {
+		return err
+	}
+
+	alias := c.Alias
+	if alias == "" {
+		alias = c.Domain
+	}
+
+	store, err := NewStoreFromOptions(ctx)
+	if err != nil {
+		return fmt.Errorf("initializing the store: %w", err)
+	}
+
+	files, err := loadSyncFiles(ctx, store, c.Domain)
+	if err != nil {
+		return err
+	}
+
+	exported, err := (&agent.Exporter{
+		Format:   c.Format,
+		Password: password,
+		Alias:    alias,
+	}).Export(files.Bundle, files.Key)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(c.Out, 0700); err != nil {
+		return err
+	}
+
+	// unlike sync, the files are written as they are and the other files in --out are never touched
+	for _, f := range exported {
+		if err := writeFileAtomic(filepath.Join(c.Out, f.Name), f.Data, os.FileMode(mode)); err != nil {
+			return fmt.Errorf("writing '%s': %w", f.Name, err)
+		}
+	}
+
+	slog.InfoContext(ctx, "exported", "email", store.Email(), "domain", c.Domain, "format", c.Format, "out", c.Out)
+
+	return nil
+}
+
+// writeFileAtomic writes data into a temporary file in the same directory and renames it to path
+// so that readers never see the partially written file.
+func writeFileAtomic(path string, data []byte, mode os.FileMode) error {
+	f, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".tmp-")
+	if err != nil {
+		return err
+	}
+
+	renamed := false
+	defer func() {
+		if !renamed {
+			f.Close()
+			os.Remove(f.Name())
+		}
+	}()
+
+	if err := f.Chmod(mode); err != nil {
+		return err
+	}
+
+	if _, err := f.Write(data); err != nil {
+		return err
+	}
+
+	if err := f.Sync(); err != nil {
+		return err
+	}
+
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	if err := os.Rename(f.Name(), path); err != nil {
+		return err
+	}
+
+	renamed = true
+
+	return nil
+}
+
+// readPassword reads the password for the keystore formats from the file.
+func readPassword(format, fn string) (string, error) {
+	switch format {
+	case agent.ExportFormatPFX, agent.ExportFormatJKS:
+	default:
+		return "", nil
+	}
+
+	if fn == "" {
+		return "", fmt.Errorf("--password-file is required for %s format", format)
+	}
+
+	blob, err := os.ReadFile(fn)
+	if err != nil {
+		return "", fmt.Errorf("reading the password file: %w", err)
+	}
+
+	password := strings.TrimRight(string(blob), "\r\n")
+	if password == "" {
+		return "", errors.New("the password file is empty")
+	}
+
+	return password, nil
+}
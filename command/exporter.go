@@ -0,0 +1,226 @@
+package command
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/nabeken/aaa/v3/agent"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+type ExporterCommand struct {
+	Listen   string        `long:"listen" description:"Address to listen on for the metrics" default:":9810"`
+	Interval time.Duration `long:"interval" description:"Interval to refresh the metrics" default:"5m"`
+}
+
+func (c *ExporterCommand) Execute(args []string) error {
+	if c.Interval <= 0 {
+		return errors.New("--interval must be positive")
+	}
+
+	ctx := context.Background()
+
+	filer, err := NewFiler(ctx)
+	if err != nil {
+		return err
+	}
+
+	svc := &ExporterService{
+		Filer:    filer,
+		Interval: c.Interval,
+	}
+
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(svc)
+
+	go svc.Loop(ctx)
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.HandlerFor(reg, promhttp.HandlerOpts{}))
+
+	slog.InfoContext(ctx, "starting the exporter", "listen", c.Listen, "interval", c.Interval)
+
+	return http.ListenAndServe(c.Listen, mux)
+}
+
+var (
+	descNotAfter = prometheus.NewDesc(
+		"aaa_certificate_not_after_seconds",
+		"The expiration time of the certificate in unix time.",
+		[]string{"email", "domain"}, nil,
+	)
+	descNotBefore = prometheus.NewDesc(
+		"aaa_certificate_not_before_seconds",
+		"The start of the validity period of the certificate in unix time.",
+		[]string{"email", "domain"}, nil,
+	)
+	descSANCount = prometheus.NewDesc(
+		"aaa_certificate_san_count",
+		"The number of Subject Alternative Names in the certificate.",
+		[]string{"email", "domain"}, nil,
+	)
+	descIssuedTotal = prometheus.NewDesc(
+		"aaa_certificate_issuance_success_total",
+		"The number of successful issuances recorded in the metadata.",
+		[]string{"email", "domain"}, nil,
+	)
+	descFailedTotal = prometheus.NewDesc(
+		"aaa_certificate_issuance_failure_total",
+		"The number of failed issuances recorded in the metadata.",
+		[]string{"email", "domain"}, nil,
+	)
+	descLastFailed = prometheus.NewDesc(
+		"aaa_certificate_last_issuance_failure_seconds",
+		"The time of the last failed issuance in unix time.",
+		[]string{"email", "domain"}, nil,
+	)
+	descLastRefresh = prometheus.NewDesc(
+		"aaa_exporter_last_refresh_seconds",
+		"The time of the last successful refresh in unix time.",
+		nil, nil,
+	)
+	descRefreshErrors = prometheus.NewDesc(
+		"aaa_exporter_refresh_errors_total",
+		"The number of failed refreshes.",
+		nil, nil,
+	)
+)
+
+// ExporterService periodically fetches the certificates from the store
+// and exposes them as Prometheus metrics. It implements prometheus.Collector.
+type ExporterService struct {
+	Filer    agent.Filer
+	Interval time.Duration
+
+	mu            sync.RWMutex
+	certs         []exportedCert
+	lastRefresh   time.Time
+	refreshErrors int
+}
+
+type exportedCert struct {
+	Domain   Domain
+	Metadata *agent.Metadata
+}
+
+// Loop refreshes the metrics every interval until ctx is canceled.
+func (svc *ExporterService) Loop(ctx context.Context) {
+	ticker := time.NewTicker(svc.Interval)
+	defer ticker.Stop()
+
+	for {
+		if err := svc.Refresh(ctx); err != nil {
+			slog.ErrorContext(ctx, "failed to refresh the metrics", "error", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Refresh fetches the certificates and the metadata from the store.
+func (svc *ExporterService) Refresh(ctx context.Context) error {
+	certs, err := svc.fetch(ctx)
+
+	svc.mu.Lock()
+	defer svc.mu.Unlock()
+
+	if err != nil {
+		svc.refreshErrors++
+		return err
+	}
+
+	svc.certs = certs
+	svc.lastRefresh = time.Now()
+
+	return nil
+}
+
+func (svc *ExporterService) fetch(ctx context.Context) ([]exportedCert, error) {
+	domains, err := (&LsService{Filer: svc.Filer}).FetchData(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	// the domains without the certificate are still exported for the issuance metrics
+	// since the failure of the first issuance is what the alert needs most
+	certs := make([]exportedCert, 0, len(domains))
+	for _, dom := range domains {
+		if dom.Error != "" {
+			slog.WarnContext(ctx, "failed to read the certificate", "email", dom.Email, "domain", dom.Domain, "error", dom.Error)
+		}
+
+		// the account itself can't be read
+		if dom.Domain == "" {
+			continue
+		}
+
+		store, err := agent.NewStore(dom.Email, svc.Filer)
+		if err != nil {
+			return nil, err
+		}
+
+		md, err := store.LoadMetadata(ctx, dom.Domain)
+		if err != nil {
+			slog.WarnContext(ctx, "failed to load the metadata", "email", dom.Email, "domain", dom.Domain, "error", err)
+			md = &agent.Metadata{}
+		}
+
+		certs = append(certs, exportedCert{Domain: dom, Metadata: md})
+	}
+
+	return certs, nil
+}
+
+func (svc *ExporterService) Describe(ch chan<- *prometheus.Desc) {
+	for _, desc := range []*prometheus.Desc{
+		descNotAfter,
+		descNotBefore,
+		descSANCount,
+		descIssuedTotal,
+		descFailedTotal,
+		descLastFailed,
+		descLastRefresh,
+		descRefreshErrors,
+	} {
+		ch <- desc
+	}
+}
+
+func (svc *ExporterService) Collect(ch chan<- prometheus.Metric) {
+	svc.mu.RLock()
+	defer svc.mu.RUnlock()
+
+	for _, c := range svc.certs {
+		labels := []string{c.Domain.Email, c.Domain.Domain}
+
+		if c.Domain.HasCert() {
+			cert := c.Domain.Certificate
+
+			ch <- prometheus.MustNewConstMetric(descNotAfter, prometheus.GaugeValue, float64(cert.NotAfter.Unix()), labels...)
+			ch <- prometheus.MustNewConstMetric(descNotBefore, prometheus.GaugeValue, float64(cert.NotBefore.Unix()), labels...)
+			ch <- prometheus.MustNewConstMetric(descSANCount, prometheus.GaugeValue, float64(len(cert.SAN)), labels...)
+		}
+
+		ch <- prometheus.MustNewConstMetric(descIssuedTotal, prometheus.CounterValue, float64(c.Metadata.IssuedCount), labels...)
+		ch <- prometheus.MustNewConstMetric(descFailedTotal, prometheus.CounterValue, float64(c.Metadata.FailedCount), labels...)
+
+		if !c.Metadata.LastFailedAt.IsZero() {
+			ch <- prometheus.MustNewConstMetric(descLastFailed, prometheus.GaugeValue, float64(c.Metadata.LastFailedAt.Unix()), labels...)
+		}
+	}
+
+	if !svc.lastRefresh.IsZero() {
+		ch <- prometheus.MustNewConstMetric(descLastRefresh, prometheus.GaugeValue, float64(svc.lastRefresh.Unix()))
+	}
+
+	ch <- prometheus.MustNewConstMetric(descRefreshErrors, prometheus.CounterValue, float64(svc.refreshErrors))
+}
@@ -0,0 +1,215 @@
+package command
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/exec"
+	"slices"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/lambda"
+	"github.com/nabeken/aaa/v3/agent"
+)
+
+// webhookTimeout limits the webhook hook so that a hung endpoint does not block the renewal.
+const webhookTimeout = 30 * time.Second
+
+var webhookClient = &http.Client{Timeout: webhookTimeout}
+
+// HookEvent is passed to the hooks after the certificate is issued.
+type HookEvent struct {
+	Email     string    `json:"email"`
+	Domain    string    `json:"domain"`
+	SAN       []string  `json:"san"`
+	Serial    string    `json:"serial"`
+	NotBefore time.Time `json:"not_before"`
+	NotAfter  time.Time `json:"not_after"`
+
+	// CertPath and KeyPath are the paths in the storage.
+	// KeyPath is empty if the private key is not in the storage, e.g. issued for the CSR or kept in KMS.
+	CertPath string `json:"cert_path"`
+	KeyPath  string `json:"key_path"`
+
+	// KeyRef locates the private key kept in KMS or PKCS#11 token.
+	KeyRef string `json:"key_ref,omitempty"`
+}
+
+// Env returns the event as environment variables.
+func (e *HookEvent) Env() []string {
+	return []string{
+		"AAA_EMAIL=" + e.Email,
+		"AAA_DOMAIN=" + e.Domain,
+		"AAA_SERIAL=" + e.Serial,
+		"AAA_NOT_AFTER=" + e.NotAfter.Format(time.RFC3339),
+		"AAA_CERT_PATH=" + e.CertPath,
+		"AAA_KEY_PATH=" + e.KeyPath,
+		"AAA_KEY_REF=" + e.KeyRef,
+	}
+}
+
+// HookRunner runs the hooks configured for the domain after the certificate is issued.
+// It is shared by the cert command, the daemon and the executor.
+type HookRunner struct {
+	Store *agent.Store
+
+	// ExtraHooks are executed in addition to the hooks in the domain configuration.
+	ExtraHooks []agent.HookConfig
+}
+
+// Run runs all the hooks for the domain. It continues even if a hook fails
+// and returns all the errors at the end.
+func (r *HookRunner) Run(ctx context.Context, domain string) error {
+	cfg, err := r.Store.LoadDomainConfig(ctx, domain)
+	if err != nil {
+		return fmt.Errorf("loading the domain config: %w", err)
+	}
+
+	hooks := append(cfg.Hooks, r.ExtraHooks...)
+
+	// the certificates in ACM targets must be kept renewed even without acm hook
+	implicitACM := len(cfg.ACMTargets) > 0 && !slices.ContainsFunc(hooks, func(h agent.HookConfig) bool {
+		return h.Type == agent.HookTypeACM
+	})
+
+	if implicitACM {
+		hooks = append(hooks, agent.HookConfig{Type: agent.HookTypeACM})
+	}
+
+	if len(hooks) == 0 {
+		return nil
+	}
+
+	event, err := r.buildEvent(ctx, domain)
+	if err != nil {
+		return fmt.Errorf("building the hook event: %w", err)
+	}
+
+	var errs []error
+	for _, hook := range hooks {
+		logger := slog.Default().With("email", event.Email, "domain", domain, "hook", hook.Type)
+		logger.InfoContext(ctx, "running the hook")
+
+		err := r.runHook(ctx, hook, event)
+
+		// the implicit acm hook can't import the key kept outside of the store
+		if implicitACM && hook.Type == agent.HookTypeACM && errors.Is(err, errNoExportableKey) {
+			logger.WarnContext(ctx, "skipping the hook", "reason", err)
+			continue
+		}
+
+		if err != nil {
+			logger.ErrorContext(ctx, "failed to run the hook", "error", err)
+			errs = append(errs, fmt.Errorf("%s hook: %w", hook.Type, err))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+func (r *HookRunner) buildEvent(ctx context.Context, domain string) (*HookEvent, error) {
+	cert, err := r.Store.LoadCert(ctx, domain)
+	if err != nil {
+		return nil, err
+	}
+
+	event := &HookEvent{
+		Email:     r.Store.Email(),
+		Domain:    domain,
+		SAN:       cert.DNSNames,
+		Serial:    cert.SerialNumber.Text(16),
+		NotBefore: cert.NotBefore,
+		NotAfter:  cert.NotAfter,
+		CertPath:  r.Store.DomainPath(domain, "cert.pem"),
+	}
+
+	ref, err := r.Store.LoadKeyRef(ctx, domain)
+	switch {
+	case err == nil:
+		event.KeyRef = ref.String()
+
+		return event, nil
+	case !errors.Is(err, agent.ErrFileNotFound):
+		return nil, fmt.Errorf("loading the key reference: %w", err)
+	}
+
+	_, err = r.Store.LoadCertKeyPEM(ctx, domain)
+	switch {
+	case err == nil:
+		event.KeyPath = r.Store.DomainPath(domain, "privkey.pem")
+	case !errors.Is(err, agent.ErrFileNotFound):
+		return nil, fmt.Errorf("loading the private key: %w", err)
+	}
+
+	return event, nil
+}
+
+func (r *HookRunner) runHook(ctx context.Context, hook agent.HookConfig, event *HookEvent) error {
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	switch hook.Type {
+	case agent.HookTypeExec:
+		cmd := exec.CommandContext(ctx, "sh", "-c", hook.Command)
+		cmd.Env = append(os.Environ(), event.Env()...)
+		cmd.Stdin = bytes.NewReader(payload)
+		cmd.Stdout = os.Stdout
+		cmd.Stderr = os.Stderr
+
+		return cmd.Run()
+
+	case agent.HookTypeWebhook:
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, hook.URL, bytes.NewReader(payload))
+		if err != nil {
+			return err
+		}
+
+		req.Header.Set("Content-Type", "application/json")
+
+		resp, err := webhookClient.Do(req)
+		if err != nil {
+			return err
+		}
+
+		defer resp.Body.Close()
+
+		if resp.StatusCode/100 != 2 {
+			respBody, _ := io.ReadAll(resp.Body)
+			return fmt.Errorf("unexpected status %d: %s", resp.StatusCode, string(respBody))
+		}
+
+		return nil
+
+	case agent.HookTypeLambda:
+		resp, err := lambda.NewFromConfig(MustNewAWSConfig(ctx)).Invoke(ctx, &lambda.InvokeInput{
+			FunctionName: aws.String(hook.FunctionName),
+			Payload:      payload,
+		})
+		if err != nil {
+			return err
+		}
+
+		if resp.FunctionError != nil {
+			return fmt.Errorf("%s: %s", aws.ToString(resp.FunctionError), string(resp.Payload))
+		}
+
+		return nil
+
+	case agent.HookTypeACM:
+		_, err := UploadToACMTargets(ctx, r.Store, event.Domain, nil, false)
+
+		return err
+
+	default:
+		return fmt.Errorf("unknown hook type '%s'", hook.Type)
+	}
+}
@@ -0,0 +1,98 @@
+package command
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+// NewKubernetesClient initializes the Kubernetes client.
+// If kubeconfig is empty, it uses in-cluster config.
+func NewKubernetesClient(kubeconfig string) (kubernetes.Interface, error) {
+	var (
+		cfg *rest.Config
+		err error
+	)
+
+	if kubeconfig == "" {
+		cfg, err = rest.InClusterConfig()
+	} else {
+		cfg, err = clientcmd.BuildConfigFromFlags("", kubeconfig)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return kubernetes.NewForConfig(cfg)
+}
+
+// k8sSecretName returns the name of the Secret for the domain.
+func k8sSecretName(domain string) string {
+	return strings.ReplaceAll(domain, "*", "wildcard") + "-tls"
+}
+
+// K8sSyncTarget writes the certificate into kubernetes.io/tls Secret.
+type K8sSyncTarget struct {
+	Client     kubernetes.Interface
+	Namespace  string
+	SecretName string
+}
+
+func (t *K8sSyncTarget) Sync(ctx context.Context, domain string, files *SyncFiles) (bool, error) {
+	secrets := t.Client.CoreV1().Secrets(t.Namespace)
+
+	data := map[string][]byte{
+		corev1.TLSCertKey:       files.FullChain,
+		corev1.TLSPrivateKeyKey: files.PrivKey,
+	}
+
+	secret, err := secrets.Get(ctx, t.SecretName, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		_, err := secrets.Create(ctx, &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      t.SecretName,
+				Namespace: t.Namespace,
+				Annotations: map[string]string{
+					"aaa.nabeken.github.io/domain": domain,
+				},
+			},
+			Type: corev1.SecretTypeTLS,
+			Data: data,
+		}, metav1.CreateOptions{})
+		if err != nil {
+			return false, fmt.Errorf("creating the secret '%s/%s': %w", t.Namespace, t.SecretName, err)
+		}
+
+		return true, nil
+	}
+
+	if err != nil {
+		return false, fmt.Errorf("getting the secret '%s/%s': %w", t.Namespace, t.SecretName, err)
+	}
+
+	if secret.Type != corev1.SecretTypeTLS {
+		return false, fmt.Errorf("the secret '%s/%s' is not %s", t.Namespace, t.SecretName, corev1.SecretTypeTLS)
+	}
+
+	if bytes.Equal(secret.Data[corev1.TLSCertKey], files.FullChain) &&
+		bytes.Equal(secret.Data[corev1.TLSPrivateKeyKey], files.PrivKey) {
+		return false, nil
+	}
+
+	secret.Data = data
+
+	if _, err := secrets.Update(ctx, secret, metav1.UpdateOptions{}); err != nil {
+		return false, fmt.Errorf("updating the secret '%s/%s': %w", t.Namespace, t.SecretName, err)
+	}
+
+	return true, nil
+}
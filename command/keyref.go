@@ -0,0 +1,57 @@
+package command
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/aws/aws-sdk-go-v2/aws/arn"
+	"github.com/aws/aws-sdk-go-v2/service/kms"
+	"github.com/nabeken/aaa/v3/agent"
+)
+
+// pkcs11PINEnv is the environment variable for the PIN of the PKCS#11 token. The PIN is never stored.
+const pkcs11PINEnv = "AAA_PKCS11_PIN"
+
+// openExternalKey returns the signer for the key referenced by ref.
+// If create is true, the key is created and ref is updated to locate it.
+func openExternalKey(ctx context.Context, ref *agent.KeyRef, description string, create bool) (agent.ExternalSigner, error) {
+	switch ref.Provider {
+	case agent.KeyProviderKMS:
+		client := newKMSClient(ctx, ref.KeyID)
+
+		if ref.KeyID != "" {
+			return agent.NewKMSSigner(ctx, client, ref.KeyID)
+		}
+
+		if !create {
+			return nil, fmt.Errorf("aaa: the KMS key is not specified")
+		}
+
+		signer, err := agent.CreateKMSKey(ctx, client, ref.KeyType, description)
+		if err != nil {
+			return nil, err
+		}
+
+		ref.KeyID = signer.KeyID()
+
+		return signer, nil
+
+	case agent.KeyProviderPKCS11:
+		return agent.OpenPKCS11Key(ref, os.Getenv(pkcs11PINEnv), create)
+
+	default:
+		return nil, fmt.Errorf("aaa: unknown key provider '%s'", ref.Provider)
+	}
+}
+
+// newKMSClient returns KMS client in the region of the key if the key is given in ARN.
+func newKMSClient(ctx context.Context, keyID string) *kms.Client {
+	cfg := MustNewAWSConfig(ctx)
+
+	if a, err := arn.Parse(keyID); err == nil {
+		cfg.Region = a.Region
+	}
+
+	return kms.NewFromConfig(cfg)
+}
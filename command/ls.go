@@ -1,34 +1,84 @@
 package command
 
 import (
+	"cmp"
 	"context"
+	"encoding/csv"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
-	"log"
+	"log/slog"
+	"math"
 	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"text/tabwriter"
 	"time"
 
-	"github.com/aws/aws-sdk-go-v2/service/s3"
 	"github.com/nabeken/aaa/v3/agent"
-	"github.com/nabeken/aws-go-s3/v2/bucket"
+	"sigs.k8s.io/yaml"
 )
 
 type LsCommand struct {
-	Format string `long:"format" description:"Format the output" default:"json"`
+	Format         string   `long:"format" description:"Format the output" choice:"json" choice:"yaml" choice:"table" choice:"csv" default:"json"`
+	Sort           string   `long:"sort" description:"Sort the domains by the key" choice:"domain" choice:"email" choice:"expiry" default:"domain"`
+	ExpiringWithin string   `long:"expiring-within" description:"Show only the certificates expiring within the duration such as 30d or 72h"`
+	DomainGlobs    []string `long:"domain-glob" description:"Show only the domains matching the glob pattern. It can be specified multiple times"`
+	States         []string `long:"state" description:"Show only the domains in the state. It can be specified multiple times" choice:"issued" choice:"failed" choice:"pending" choice:"orphaned" choice:"unreadable"`
+	Concurrency    int      `long:"concurrency" description:"Number of concurrent requests to the storage" default:"8"`
+	Accounts       bool     `long:"accounts" description:"List the registrations of the accounts instead of the domains"`
 }
 
 func (c *LsCommand) Execute(args []string) error {
 	ctx := context.Background()
-	s3b := bucket.New(s3.NewFromConfig(MustNewAWSConfig(ctx)), Options.S3Bucket)
 
-	return (&LsService{
-		Filer: agent.NewS3Filer(s3b, Options.S3KMSKeyID),
-	}).WriteTo(ctx, c.Format, os.Stdout)
+	filer, err := NewFiler(ctx)
+	if err != nil {
+		return err
+	}
+
+	svc := &LsService{
+		Filer:       filer,
+		Email:       Options.Email,
+		DomainGlobs: c.DomainGlobs,
+		States:      c.States,
+		Sort:        c.Sort,
+		Concurrency: c.Concurrency,
+	}
+
+	if c.Accounts {
+		return svc.WriteAccountsTo(ctx, c.Format, os.Stdout)
+	}
+
+	if c.ExpiringWithin != "" {
+		if svc.ExpiringWithin, err = agent.ParseDays(c.ExpiringWithin); err != nil {
+			return fmt.Errorf("parsing --expiring-within: %w", err)
+		}
+	}
+
+	return svc.WriteTo(ctx, c.Format, os.Stdout)
 }
 
+// defaultLsConcurrency is the number of the concurrent requests to the storage in FetchData.
+const defaultLsConcurrency = 8
+
 type LsService struct {
 	Filer agent.Filer
+
+	// Concurrency is the number of the concurrent requests to the storage. defaultLsConcurrency is used if zero.
+	Concurrency int
+
+	// Email limits the accounts to be listed if not empty.
+	Email string
+
+	// DomainGlobs, States, ExpiringWithin and Sort are applied to the output of WriteTo.
+	DomainGlobs    []string
+	States         []string
+	ExpiringWithin time.Duration
+	Sort           string
 }
 
 func (svc *LsService) WriteTo(ctx context.Context, format string, w io.Writer) error {
@@ -37,55 +87,229 @@ func (svc *LsService) WriteTo(ctx context.Context, format string, w io.Writer) e
 		return err
 	}
 
+	output, err = svc.filter(output, time.Now())
+	if err != nil {
+		return err
+	}
+
+	svc.sort(output)
+
 	switch format {
 	case "json":
 		return json.NewEncoder(w).Encode(output)
+	case "yaml":
+		blob, err := yaml.Marshal(output)
+		if err != nil {
+			return err
+		}
+
+		_, err = w.Write(blob)
+
+		return err
+	case "table":
+		return writeTable(w, lsColumns, output)
+	case "csv":
+		return writeCSV(w, lsColumns, output)
 	default:
 		return fmt.Errorf("'%s' is not implemented", format)
 	}
 }
 
-func (svc *LsService) FetchData(ctx context.Context) ([]Domain, error) {
-	data := []Domain{}
+func (svc *LsService) filter(domains []Domain, now time.Time) ([]Domain, error) {
+	filtered := []Domain{}
+	for _, dom := range domains {
+		if svc.ExpiringWithin > 0 && (!dom.HasCert() || dom.Certificate.NotAfter.After(now.Add(svc.ExpiringWithin))) {
+			continue
+		}
 
-	emails, err := svc.listAccounts(ctx)
-	if err != nil {
-		return nil, fmt.Errorf("listing the accounts: %w", err)
-	}
+		if len(svc.States) > 0 && !slices.Contains(svc.States, dom.State) {
+			continue
+		}
 
-	for _, email := range emails {
-		store, err := agent.NewStore(email, svc.Filer)
+		ok, err := matchGlobs(svc.DomainGlobs, dom.Domain)
 		if err != nil {
-			return nil, fmt.Errorf("initializing the store: %w", err)
+			return nil, err
 		}
 
-		domains, err := store.ListDomains(ctx)
-		if err != nil {
-			return nil, fmt.Errorf("listing the domains: %w", err)
+		if ok {
+			filtered = append(filtered, dom)
+		}
+	}
+
+	return filtered, nil
+}
+
+func (svc *LsService) sort(domains []Domain) {
+	slices.SortStableFunc(domains, func(a, b Domain) int {
+		switch svc.Sort {
+		case "email":
+			return cmp.Or(strings.Compare(a.Email, b.Email), strings.Compare(a.Domain, b.Domain))
+		case "expiry":
+			return cmp.Or(a.Certificate.NotAfter.Compare(b.Certificate.NotAfter), strings.Compare(a.Domain, b.Domain))
+		default:
+			return cmp.Or(strings.Compare(a.Domain, b.Domain), strings.Compare(a.Email, b.Email))
+		}
+	})
+}
+
+// FetchData returns all the domains in the accounts. It reads the index of the account if it exists,
+// otherwise it walks the domains concurrently. The errors for each account or domain are reported
+// in Domain.Error instead of failing the whole listing.
+func (svc *LsService) FetchData(ctx context.Context) ([]Domain, error) {
+	now := time.Now()
+
+	emails := []string{svc.Email}
+	if svc.Email == "" {
+		var err error
+		if emails, err = svc.listAccounts(ctx); err != nil {
+			return nil, fmt.Errorf("listing the accounts: %w", err)
 		}
+	}
+
+	var (
+		mu   sync.Mutex
+		data = []Domain{}
+	)
+
+	add := func(dom Domain) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		data = append(data, dom)
+	}
+
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, cmp.Or(svc.Concurrency, defaultLsConcurrency))
+
+	run := func(f func()) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			sem <- struct{}{}
+			defer func() { <-sem }()
+
+			f()
+		}()
+	}
+
+	type target struct {
+		store  *agent.Store
+		domain string
+	}
+
+	var targets []target
+
+	for _, email := range emails {
+		run(func() {
+			store, err := agent.NewStore(email, svc.Filer)
+			if err != nil {
+				add(Domain{Email: email, Error: err.Error()})
+				return
+			}
+
+			idx, err := store.LoadIndex(ctx)
+			if err == nil {
+				for dom, entry := range idx.Domains {
+					add(newDomain(email, dom, entry, now))
+				}
+
+				return
+			}
+
+			if !errors.Is(err, agent.ErrFileNotFound) {
+				slog.WarnContext(ctx, "failed to load the index. walking the domains...", "email", email, "error", err)
+			}
 
-		for _, dom := range domains {
-			cert, err := store.LoadCert(ctx, dom)
+			domains, err := store.ListDomains(ctx)
 			if err != nil {
-				log.Printf("failed to load certificate for %s: %s (or new-cert is ongoing or this domain is in SAN in other certificates). skipping...", dom, err)
-				continue
+				add(Domain{Email: email, Error: fmt.Sprintf("listing the domains: %s", err)})
+				return
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+
+			for _, dom := range domains {
+				targets = append(targets, target{store: store, domain: dom})
 			}
+		})
+	}
+
+	wg.Wait()
+
+	for _, t := range targets {
+		run(func() {
+			entry, err := t.store.LoadIndexEntry(ctx, t.domain)
+			if err != nil {
+				add(Domain{Email: t.store.Email(), Domain: t.domain, State: agent.DomainStateUnreadable, Error: err.Error()})
+				return
+			}
+
+			add(newDomain(t.store.Email(), t.domain, entry, now))
+		})
+	}
 
-			data = append(data, Domain{
-				Email:  email,
-				Domain: dom,
-				Certificate: Certificate{
-					NotBefore: cert.NotBefore,
-					NotAfter:  cert.NotAfter,
-					SAN:       cert.DNSNames,
-				},
-			})
+	wg.Wait()
+
+	markOrphans(data)
+
+	for i, dom := range data {
+		if display := agent.DisplayDomain(dom.Domain); display != dom.Domain {
+			data[i].DomainUnicode = display
 		}
 	}
 
+	slices.SortFunc(data, func(a, b Domain) int {
+		return cmp.Or(strings.Compare(a.Email, b.Email), strings.Compare(a.Domain, b.Domain))
+	})
+
 	return data, nil
 }
 
+// markOrphans marks the pending domains that are covered by the certificate of another domain
+// in the same account as orphaned. They are left by the old versions that issued the certificate per domain.
+func markOrphans(domains []Domain) {
+	covered := map[string]bool{}
+	for _, dom := range domains {
+		if dom.HasCert() {
+			for _, san := range dom.SANWithoutCommonName() {
+				covered[dom.Email+"/"+san] = true
+			}
+		}
+	}
+
+	for i, dom := range domains {
+		if dom.State == agent.DomainStatePending && covered[dom.Email+"/"+dom.Domain] {
+			domains[i].State = agent.DomainStateOrphaned
+		}
+	}
+}
+
+func newDomain(email, domain string, entry *agent.IndexEntry, now time.Time) Domain {
+	dom := Domain{
+		Email:     email,
+		Domain:    domain,
+		State:     entry.State,
+		LastError: entry.LastError,
+		Error:     entry.Error,
+	}
+
+	if entry.HasCert() {
+		dom.Certificate = Certificate{
+			NotBefore:     entry.NotBefore,
+			NotAfter:      entry.NotAfter,
+			DaysRemaining: daysRemaining(entry.NotAfter, now),
+			SAN:           entry.SAN,
+			Issuer:        entry.Issuer,
+			KeyType:       entry.KeyType,
+			Serial:        entry.Serial,
+		}
+	}
+
+	return dom
+}
+
 func (svc *LsService) listAccounts(ctx context.Context) ([]string, error) {
 	dirs, err := svc.Filer.ListDir(ctx, agent.StorePrefix)
 	if err != nil {
@@ -96,9 +320,9 @@ func (svc *LsService) listAccounts(ctx context.Context) ([]string, error) {
 	for _, dir := range dirs {
 		elem := svc.Filer.Split(dir)
 
-		// account (email) is in 2nd element
-		if len(elem) > 1 {
-			accounts = append(accounts, elem[1])
+		// account (email) is in the last element
+		if email := elem[len(elem)-1]; email != "" {
+			accounts = append(accounts, email)
 		}
 	}
 
@@ -106,13 +330,190 @@ func (svc *LsService) listAccounts(ctx context.Context) ([]string, error) {
 }
 
 type Domain struct {
-	Email       string      `json:"email"`
-	Domain      string      `json:"domain"`
+	Email  string `json:"email"`
+	Domain string `json:"domain"`
+	State  string `json:"state"`
+
+	// DomainUnicode is the domain in U-labels if it is an IDN.
+	DomainUnicode string `json:"domain_unicode,omitempty"`
+
 	Certificate Certificate `json:"certificate"`
+
+	// LastError is the error of the last failed issuance.
+	LastError string `json:"last_error,omitempty"`
+
+	// Error is set if the account or the domain can't be read.
+	Error string `json:"error,omitempty"`
+}
+
+// HasCert reports whether the domain has the certificate.
+func (d Domain) HasCert() bool {
+	return !d.Certificate.NotAfter.IsZero()
 }
 
 type Certificate struct {
-	NotBefore time.Time `json:"not_before"`
-	NotAfter  time.Time `json:"not_after"`
-	SAN       []string  `json:"san"`
+	NotBefore     time.Time `json:"not_before"`
+	NotAfter      time.Time `json:"not_after"`
+	DaysRemaining int       `json:"days_remaining"`
+	SAN           []string  `json:"san"`
+	Issuer        string    `json:"issuer"`
+	KeyType       string    `json:"key_type"`
+	Serial        string    `json:"serial"`
+}
+
+var lsColumns = []string{"EMAIL", "DOMAIN", "STATE", "NOT_AFTER", "DAYS", "ISSUER", "KEY", "SERIAL", "SAN", "ERROR"}
+
+func (d Domain) columns() []string {
+	var notAfter, days string
+	if d.HasCert() {
+		notAfter = d.Certificate.NotAfter.Format(time.RFC3339)
+		days = strconv.Itoa(d.Certificate.DaysRemaining)
+	}
+
+	return []string{
+		d.Email,
+		cmp.Or(d.DomainUnicode, d.Domain),
+		d.State,
+		notAfter,
+		days,
+		d.Certificate.Issuer,
+		d.Certificate.KeyType,
+		d.Certificate.Serial,
+		strings.Join(d.Certificate.SAN, ","),
+		cmp.Or(d.Error, d.LastError),
+	}
+}
+
+type row interface {
+	columns() []string
+}
+
+func writeTable[T row](w io.Writer, header []string, rows []T) error {
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+
+	fmt.Fprintln(tw, strings.Join(header, "\t"))
+	for _, r := range rows {
+		fmt.Fprintln(tw, strings.Join(r.columns(), "\t"))
+	}
+
+	return tw.Flush()
+}
+
+func writeCSV[T row](w io.Writer, header []string, rows []T) error {
+	cw := csv.NewWriter(w)
+
+	cw.Write(header)
+	for _, r := range rows {
+		cw.Write(r.columns())
+	}
+
+	cw.Flush()
+
+	return cw.Error()
+}
+
+// Account is the registration of the account.
+type Account struct {
+	Email        string    `json:"email"`
+	AccountURL   string    `json:"account_url"`
+	DirectoryURL string    `json:"directory_url"`
+	CreatedAt    time.Time `json:"created_at,omitzero"`
+	Domains      int       `json:"domains"`
+
+	// Error is set if the registration can't be read.
+	Error string `json:"error,omitempty"`
+}
+
+var accountColumns = []string{"EMAIL", "ACCOUNT_URL", "DIRECTORY_URL", "CREATED_AT", "DOMAINS", "ERROR"}
+
+func (a Account) columns() []string {
+	var createdAt string
+	if !a.CreatedAt.IsZero() {
+		createdAt = a.CreatedAt.Format(time.RFC3339)
+	}
+
+	return []string{a.Email, a.AccountURL, a.DirectoryURL, createdAt, strconv.Itoa(a.Domains), a.Error}
+}
+
+// FetchAccounts returns the registrations of all the accounts including the accounts without domains.
+func (svc *LsService) FetchAccounts(ctx context.Context) ([]Account, error) {
+	emails := []string{svc.Email}
+	if svc.Email == "" {
+		var err error
+		if emails, err = svc.listAccounts(ctx); err != nil {
+			return nil, fmt.Errorf("listing the accounts: %w", err)
+		}
+	}
+
+	accounts := make([]Account, len(emails))
+	for i, email := range emails {
+		accounts[i] = svc.fetchAccount(ctx, email)
+	}
+
+	return accounts, nil
+}
+
+func (svc *LsService) fetchAccount(ctx context.Context, email string) Account {
+	acct := Account{Email: email}
+
+	store, err := agent.NewStore(email, svc.Filer)
+	if err != nil {
+		acct.Error = err.Error()
+		return acct
+	}
+
+	ri, err := store.LoadRegistration(ctx)
+	if err != nil {
+		acct.Error = fmt.Sprintf("loading the registration: %s", err)
+		return acct
+	}
+
+	if ri.Registration != nil {
+		acct.AccountURL = ri.Registration.URI
+	}
+
+	acct.DirectoryURL = ri.DirectoryURL
+	acct.CreatedAt = ri.CreatedAt
+
+	domains, err := store.ListDomains(ctx)
+	if err != nil {
+		acct.Error = fmt.Sprintf("listing the domains: %s", err)
+		return acct
+	}
+
+	acct.Domains = len(domains)
+
+	return acct
+}
+
+func (svc *LsService) WriteAccountsTo(ctx context.Context, format string, w io.Writer) error {
+	accounts, err := svc.FetchAccounts(ctx)
+	if err != nil {
+		return err
+	}
+
+	switch format {
+	case "json":
+		return json.NewEncoder(w).Encode(accounts)
+	case "yaml":
+		blob, err := yaml.Marshal(accounts)
+		if err != nil {
+			return err
+		}
+
+		_, err = w.Write(blob)
+
+		return err
+	case "table":
+		return writeTable(w, accountColumns, accounts)
+	case "csv":
+		return writeCSV(w, accountColumns, accounts)
+	default:
+		return fmt.Errorf("'%s' is not implemented", format)
+	}
+}
+
+// daysRemaining returns the number of whole days until notAfter.
+func daysRemaining(notAfter, now time.Time) int {
+	return int(math.Floor(notAfter.Sub(now).Hours() / 24))
 }
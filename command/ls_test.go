@@ -0,0 +1,53 @@
+package command
+
+import (
+	"testing"
+	"time"
+
+	"github.com/nabeken/aaa/v3/agent"
+)
+
+func TestMarkOrphans(t *testing.T) {
+	notAfter := time.Date(2025, 6, 14, 0, 0, 0, 0, time.UTC)
+
+	issued := func(email, domain string, san ...string) Domain {
+		return Domain{
+			Email:       email,
+			Domain:      domain,
+			State:       agent.DomainStateIssued,
+			Certificate: Certificate{NotAfter: notAfter, SAN: append([]string{domain}, san...)},
+		}
+	}
+
+	pending := func(email, domain string) Domain {
+		return Domain{Email: email, Domain: domain, State: agent.DomainStatePending}
+	}
+
+	domains := []Domain{
+		issued("a@example.com", "example.com", "www.example.com"),
+		pending("a@example.com", "www.example.com"),
+		pending("a@example.com", "new.example.com"),
+
+		// covered by the certificate in another account
+		pending("b@example.com", "www.example.com"),
+
+		// the certificate is never covered by its own CommonName
+		pending("a@example.com", "example.com"),
+	}
+
+	want := []string{
+		agent.DomainStateIssued,
+		agent.DomainStateOrphaned,
+		agent.DomainStatePending,
+		agent.DomainStatePending,
+		agent.DomainStatePending,
+	}
+
+	markOrphans(domains)
+
+	for i, dom := range domains {
+		if dom.State != want[i] {
+			t.Errorf("%s %s: State = %q, want %q", dom.Email, dom.Domain, dom.State, want[i])
+		}
+	}
+}
@@ -6,7 +6,8 @@ import (
 	"crypto/ecdsa"
 	"crypto/elliptic"
 	"crypto/rand"
-	"log"
+	"log/slog"
+	"time"
 
 	"github.com/go-acme/lego/v4/registration"
 	"github.com/go-jose/go-jose/v4"
@@ -26,7 +27,7 @@ func (c *RegCommand) Execute(args []string) error {
 	)
 
 	// initialize S3 bucket and filer
-	store, err := NewStore(Options.Email, Options.S3Bucket, Options.S3KMSKeyID)
+	store, err := NewStoreFromOptions(ctx)
 	if err != nil {
 		return err
 	}
@@ -37,11 +38,11 @@ func (c *RegCommand) Execute(args []string) error {
 	}
 
 	if err == nil && !c.Override {
-		log.Println("INFO: found the existing registration. Please set --override to register with a new key.")
+		slog.InfoContext(ctx, "found the existing registration. Please set --override to register with a new key.", "email", Options.Email)
 		return nil
 	}
 
-	log.Println("INFO: creating new account key pair...")
+	slog.InfoContext(ctx, "creating new account key pair", "email", Options.Email, "step", "create-key")
 
 	privKey, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
 	if err != nil {
@@ -61,11 +62,11 @@ func (c *RegCommand) Execute(args []string) error {
 	}
 
 	if !c.AgreeTOS {
-		log.Printf("Please agree with TOS found at %s with --agree-tos", client.GetToSURL())
+		slog.InfoContext(ctx, "Please agree with TOS with --agree-tos", "tos_url", client.GetToSURL())
 		return nil
 	}
 
-	log.Println("INFO: registering account...")
+	slog.InfoContext(ctx, "registering account", "email", Options.Email, "step", "register")
 
 	reg, err := client.Registration.Register(registration.RegisterOptions{
 		TermsOfServiceAgreed: c.AgreeTOS,
@@ -74,15 +75,17 @@ func (c *RegCommand) Execute(args []string) error {
 		return err
 	}
 
-	log.Printf("DEBUG: RegistrationInfo: %#v\n", reg)
+	slog.DebugContext(ctx, "registration is done", "email", Options.Email, "account_url", reg.URI)
 
 	ri.Registration = reg
+	ri.DirectoryURL = agent.DirectoryURL()
+	ri.CreatedAt = time.Now()
 	if err := store.SaveRegistration(ctx, ri); err != nil {
-		log.Println("ERROR: unable to save the registration")
+		slog.ErrorContext(ctx, "unable to save the registration", "email", Options.Email, "error", err)
 		return err
 	}
 
-	log.Printf("INFO: registration has been done")
+	slog.InfoContext(ctx, "registration has been done", "email", Options.Email, "step", "save")
 
 	return nil
 }
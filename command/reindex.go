@@ -0,0 +1,74 @@
+package command
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+
+	"github.com/nabeken/aaa/v3/agent"
+)
+
+type ReindexCommand struct{}
+
+func (c *ReindexCommand) Execute(args []string) error {
+	ctx := context.Background()
+
+	filer, err := NewFiler(ctx)
+	if err != nil {
+		return err
+	}
+
+	return (&ReindexService{
+		Filer: filer,
+		Email: Options.Email,
+	}).Run(ctx)
+}
+
+// ReindexService rebuilds the index of the certificates from scratch.
+type ReindexService struct {
+	Filer agent.Filer
+
+	// Email limits the account to be reindexed if not empty.
+	Email string
+}
+
+func (svc *ReindexService) Run(ctx context.Context) error {
+	emails := []string{svc.Email}
+	if svc.Email == "" {
+		var err error
+		if emails, err = (&LsService{Filer: svc.Filer}).listAccounts(ctx); err != nil {
+			return fmt.Errorf("listing the accounts: %w", err)
+		}
+	}
+
+	var errs []error
+	for _, email := range emails {
+		if err := svc.reindex(ctx, email); err != nil {
+			slog.ErrorContext(ctx, "failed to rebuild the index", "email", email, "error", err)
+			errs = append(errs, fmt.Errorf("%s: %w", email, err))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+func (svc *ReindexService) reindex(ctx context.Context, email string) error {
+	store, err := agent.NewStore(email, svc.Filer)
+	if err != nil {
+		return err
+	}
+
+	idx, err := store.BuildIndex(ctx)
+	if err != nil {
+		return err
+	}
+
+	if err := store.SaveIndex(ctx, idx); err != nil {
+		return fmt.Errorf("saving the index: %w", err)
+	}
+
+	slog.InfoContext(ctx, "the index has been rebuilt", "email", email, "domains", len(idx.Domains))
+
+	return nil
+}
@@ -0,0 +1,38 @@
+package command
+
+import (
+	"slices"
+	"time"
+)
+
+// DefaultRenewalDaysBefore is the number of days before the expiration to renew the certificate.
+const DefaultRenewalDaysBefore = 30
+
+// RenewalTargets returns domains whose certificate expires within days from now.
+// For the short-lived certificate, the window is shortened to the last third of its lifetime
+// so that it is not renewed on every run. The domains without the certificate are not included.
+func RenewalTargets(domains []Domain, now time.Time, days int) []Domain {
+	window := time.Duration(days) * 24 * time.Hour
+
+	targets := []Domain{}
+	for _, domain := range domains {
+		if domain.Error != "" || !domain.HasCert() {
+			continue
+		}
+
+		cert := domain.Certificate
+		if cert.NotAfter.Before(now.Add(min(window, cert.NotAfter.Sub(cert.NotBefore)/3))) {
+			targets = append(targets, domain)
+		}
+	}
+
+	return targets
+}
+
+// SANWithoutCommonName returns the Subject Alternative Names of the domain except the CommonName
+// so that it can be passed to CertService.Domains on renewal.
+func (d Domain) SANWithoutCommonName() []string {
+	return slices.DeleteFunc(slices.Clone(d.Certificate.SAN), func(san string) bool {
+		return san == d.Domain
+	})
+}
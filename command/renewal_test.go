@@ -0,0 +1,92 @@
+package command
+
+import (
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestRenewalTargets(t *testing.T) {
+	now := time.Date(2025, 3, 16, 0, 0, 0, 0, time.UTC)
+	day := 24 * time.Hour
+
+	// cert returns the domain with the certificate valid for lifetime that expires in remaining
+	cert := func(name string, lifetime, remaining time.Duration) Domain {
+		notAfter := now.Add(remaining)
+
+		return Domain{
+			Domain: name,
+			Certificate: Certificate{
+				NotBefore: notAfter.Add(-lifetime),
+				NotAfter:  notAfter,
+			},
+		}
+	}
+
+	withError := cert("error.example.com", 90*day, day)
+	withError.Error = "loading the certificate: broken"
+
+	for _, tc := range []struct {
+		name    string
+		domains []Domain
+		days    int
+		want    []string
+	}{
+		{
+			name: "within the days",
+			domains: []Domain{
+				cert("expiring.example.com", 90*day, 29*day),
+				cert("fresh.example.com", 90*day, 31*day),
+			},
+			days: 30,
+			want: []string{"expiring.example.com"},
+		},
+		{
+			name: "already expired",
+			domains: []Domain{
+				cert("expired.example.com", 90*day, -day),
+			},
+			days: 30,
+			want: []string{"expired.example.com"},
+		},
+		{
+			// the window is the last third of the lifetime for the short-lived certificates
+			name: "short-lived",
+			domains: []Domain{
+				cert("renew.example.com", 6*day, 47*time.Hour),
+				cert("keep.example.com", 6*day, 49*time.Hour),
+			},
+			days: 30,
+			want: []string{"renew.example.com"},
+		},
+		{
+			name: "days shorter than the third of the lifetime",
+			domains: []Domain{
+				cert("renew.example.com", 90*day, 6*day),
+				cert("keep.example.com", 90*day, 8*day),
+			},
+			days: 7,
+			want: []string{"renew.example.com"},
+		},
+		{
+			name: "without the certificate or with the error",
+			domains: []Domain{
+				{Domain: "pending.example.com"},
+				withError,
+			},
+			days: 30,
+			want: []string{},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var got []string
+			for _, dom := range RenewalTargets(tc.domains, now, tc.days) {
+				got = append(got, dom.Domain)
+			}
+
+			if !slices.Equal(got, tc.want) && len(got)+len(tc.want) > 0 {
+				t.Errorf("RenewalTargets() = %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,47 @@
+package command
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+
+	"github.com/nabeken/aaa/v3/agent"
+)
+
+type RmCommand struct {
+	Domain string `long:"domain" description:"Domain to be removed" required:"true"`
+}
+
+func (c *RmCommand) Execute(args []string) error {
+	ctx := context.Background()
+
+	domain, err := agent.NormalizeDomain(c.Domain)
+	if err != nil {
+		return err
+	}
+
+	store, err := NewStoreFromOptions(ctx)
+	if err != nil {
+		return fmt.Errorf("initializing the store: %w", err)
+	}
+
+	ref, err := store.LoadKeyRef(ctx, domain)
+	if err != nil && !errors.Is(err, agent.ErrFileNotFound) {
+		return fmt.Errorf("loading the key reference: %w", err)
+	}
+
+	if err := store.RemoveDomain(ctx, domain); err != nil {
+		return fmt.Errorf("removing the domain: %w", err)
+	}
+
+	logger := slog.Default().With("email", store.Email(), "domain", domain)
+
+	if ref != nil {
+		logger.WarnContext(ctx, "the external key is not deleted. delete it in the provider if it is no longer used", "key", ref.String())
+	}
+
+	logger.InfoContext(ctx, "domain has been removed")
+
+	return nil
+}
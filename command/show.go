@@ -0,0 +1,260 @@
+package command
+
+import (
+	"context"
+	"crypto"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/nabeken/aaa/v3/agent"
+)
+
+// Status of the private key against the certificate.
+const (
+	keyStatusMatch    = "match"
+	keyStatusMismatch = "mismatch"
+	keyStatusMissing  = "missing"
+	keyStatusInvalid  = "invalid"
+)
+
+type ShowCommand struct {
+	Domain  string `long:"domain" description:"Domain to be shown" required:"true"`
+	Format  string `long:"format" description:"Output format" choice:"text" choice:"json" default:"text"`
+	NoFetch bool   `long:"no-fetch" description:"Do not fetch the missing intermediate certificates via AIA"`
+}
+
+func (c *ShowCommand) Execute(args []string) error {
+	ctx := context.Background()
+
+	domain, err := agent.NormalizeDomain(c.Domain)
+	if err != nil {
+		return err
+	}
+
+	store, err := NewStoreFromOptions(ctx)
+	if err != nil {
+		return fmt.Errorf("initializing the store: %w", err)
+	}
+
+	details, err := (&ShowService{
+		Domain:  domain,
+		Store:   store,
+		NoFetch: c.NoFetch,
+	}).Run(ctx)
+	if err != nil {
+		return err
+	}
+
+	if c.Format == "json" {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+
+		return enc.Encode(details)
+	}
+
+	return details.WriteText(os.Stdout)
+}
+
+// ShowService inspects the certificate and the private key in the store.
+type ShowService struct {
+	Domain string
+	Store  *agent.Store
+
+	// NoFetch disables fetching the intermediate certificates that are not bundled.
+	NoFetch bool
+}
+
+// CertDetails is everything about the stored certificate.
+type CertDetails struct {
+	Email       string            `json:"email"`
+	Domain      string            `json:"domain"`
+	Certificate *agent.CertInfo   `json:"certificate"`
+	Chain       []*agent.CertInfo `json:"chain"`
+
+	// ChainFetched is true if the chain is not bundled and fetched via AIA.
+	ChainFetched bool `json:"chain_fetched"`
+
+	// ChainValid reports whether the chain validates against the system roots.
+	ChainValid bool   `json:"chain_valid"`
+	ChainError string `json:"chain_error,omitempty"`
+
+	// Root is the subject of the root certificate that the chain validates against.
+	Root string `json:"root,omitempty"`
+
+	// Key is one of match, mismatch, missing and invalid.
+	Key      string `json:"key"`
+	KeyError string `json:"key_error,omitempty"`
+
+	// KeyRef is set if the private key is kept outside of the store.
+	KeyRef *agent.KeyRef `json:"key_ref,omitempty"`
+}
+
+func (svc *ShowService) Run(ctx context.Context) (*CertDetails, error) {
+	blob, err := svc.Store.LoadCertPEM(ctx, svc.Domain)
+	if err != nil {
+		return nil, fmt.Errorf("reading the certificate: %w", err)
+	}
+
+	bundle, err := agent.ParseCertBundle(blob)
+	if err != nil {
+		return nil, err
+	}
+
+	details := &CertDetails{
+		Email:       svc.Store.Email(),
+		Domain:      svc.Domain,
+		Certificate: agent.NewCertInfo(bundle.Leaf),
+	}
+
+	if len(bundle.Chain) == 0 && !svc.NoFetch {
+		if err := bundle.CompleteChain(ctx); err != nil {
+			details.ChainError = err.Error()
+		}
+
+		details.ChainFetched = len(bundle.Chain) > 0
+	}
+
+	for _, cert := range bundle.Chain {
+		details.Chain = append(details.Chain, agent.NewCertInfo(cert))
+	}
+
+	if details.ChainError == "" {
+		svc.verifyChain(details, bundle)
+	}
+
+	svc.checkKey(ctx, details, bundle)
+
+	return details, nil
+}
+
+func (svc *ShowService) verifyChain(details *CertDetails, bundle *agent.CertBundle) {
+	intermediates := x509.NewCertPool()
+	for _, cert := range bundle.Chain {
+		intermediates.AddCert(cert)
+	}
+
+	chains, err := bundle.Leaf.Verify(x509.VerifyOptions{
+		Intermediates: intermediates,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	})
+	if err != nil {
+		details.ChainError = err.Error()
+		return
+	}
+
+	chain := chains[0]
+
+	details.ChainValid = true
+	details.Root = chain[len(chain)-1].Subject.String()
+}
+
+func (svc *ShowService) checkKey(ctx context.Context, details *CertDetails, bundle *agent.CertBundle) {
+	var key crypto.PrivateKey
+
+	ref, err := svc.Store.LoadKeyRef(ctx, svc.Domain)
+	if err == nil {
+		details.KeyRef = ref
+
+		var signer agent.ExternalSigner
+		if signer, err = openExternalKey(ctx, ref, "", false); err == nil {
+			defer signer.Close()
+
+			key = signer
+		}
+	} else if errors.Is(err, agent.ErrFileNotFound) {
+		key, err = svc.Store.LoadCertKey(ctx, svc.Domain)
+	}
+
+	switch {
+	case errors.Is(err, agent.ErrFileNotFound):
+		details.Key = keyStatusMissing
+	case err != nil:
+		details.Key = keyStatusInvalid
+		details.KeyError = err.Error()
+	default:
+		details.Key = keyStatusMatch
+		if err := bundle.VerifyKey(key); err != nil {
+			details.Key = keyStatusMismatch
+			details.KeyError = err.Error()
+		}
+	}
+}
+
+// WriteText writes the details in the human-readable form.
+func (d *CertDetails) WriteText(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+
+	fmt.Fprintf(tw, "Email:\t%s\n", d.Email)
+	domain := d.Domain
+	if display := agent.DisplayDomain(d.Domain); display != d.Domain {
+		domain = display + " (" + d.Domain + ")"
+	}
+
+	fmt.Fprintf(tw, "Domain:\t%s\n", domain)
+	writeCertInfo(tw, d.Certificate, "")
+
+	key := d.Key
+	if d.KeyError != "" {
+		key += " (" + d.KeyError + ")"
+	}
+
+	if d.KeyRef != nil {
+		key += " in " + d.KeyRef.String()
+	}
+
+	fmt.Fprintf(tw, "Private Key:\t%s\n", key)
+
+	chain := "valid"
+	if !d.ChainValid {
+		chain = "invalid (" + d.ChainError + ")"
+	}
+
+	if d.ChainFetched {
+		chain += ", fetched via AIA"
+	}
+
+	fmt.Fprintf(tw, "Chain:\t%s\n", chain)
+	if d.Root != "" {
+		fmt.Fprintf(tw, "Root:\t%s\n", d.Root)
+	}
+
+	for i, cert := range d.Chain {
+		fmt.Fprintf(tw, "\nIntermediate #%d:\n", i+1)
+		writeCertInfo(tw, cert, "  ")
+	}
+
+	return tw.Flush()
+}
+
+func writeCertInfo(w io.Writer, info *agent.CertInfo, indent string) {
+	fields := [][2]string{
+		{"Subject", info.Subject},
+		{"Issuer", info.Issuer},
+		{"SAN", strings.Join(info.SAN, ", ")},
+		{"Serial", info.Serial},
+		{"Not Before", info.NotBefore.Format(time.RFC3339)},
+		{"Not After", info.NotAfter.Format(time.RFC3339)},
+		{"Key Type", info.KeyType},
+		{"Signature Algorithm", info.SignatureAlgorithm},
+		{"SHA-256 Fingerprint", info.SHA256Fingerprint},
+		{"SPKI Pin (SHA-256)", info.SPKIPin},
+		{"OCSP", strings.Join(info.OCSPServers, ", ")},
+		{"CRL", strings.Join(info.CRLDistributionPoints, ", ")},
+		{"CA Issuers", strings.Join(info.IssuingCertificateURL, ", ")},
+	}
+
+	for _, f := range fields {
+		if f[1] == "" {
+			continue
+		}
+
+		fmt.Fprintf(w, "%s%s:\t%s\n", indent, f[0], f[1])
+	}
+}
@@ -2,50 +2,666 @@ package command
 
 import (
 	"context"
-	"log"
+	"crypto"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"os/exec"
+	"os/signal"
+	"os/user"
+	"path"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"sync"
+	"syscall"
+	"text/tabwriter"
+	"time"
 
-	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/go-acme/lego/v4/certcrypto"
 	"github.com/nabeken/aaa/v3/agent"
-	"github.com/nabeken/aws-go-s3/v2/bucket"
 )
 
 type SyncCommand struct {
-	Domain string `long:"domain" description:"Domain to be synced" required:"true"`
+	Domains     []string `long:"domain" description:"Domain to be synced. It can be specified multiple times"`
+	DomainGlobs []string `long:"domain-glob" description:"Sync the domains matching the glob pattern. It can be specified multiple times"`
+	All         bool     `long:"all" description:"Sync all the domains for the account"`
+	Concurrency int      `long:"concurrency" description:"Number of domains to be synced concurrently" default:"4"`
 
-	s3Filer *agent.S3Filer
-	osFiler *agent.OSFiler
-}
+	Target   string `long:"target" description:"Where the certificate is synced to" choice:"file" choice:"k8s" default:"file"`
+	Format   string `long:"format" description:"Format of the files for file target" choice:"pem" choice:"der" choice:"pfx" choice:"jks" default:"pem"`
+	OnChange string `long:"on-change" description:"Command to be executed by sh for each domain only when the content has been changed"`
+
+	Watch       bool          `long:"watch" description:"Keep running and re-sync whenever the certificate is updated"`
+	Interval    time.Duration `long:"interval" description:"Interval to poll the version of the certificate with --watch" default:"5m"`
+	SQSQueueURL string        `long:"sqs-queue-url" description:"SQS queue receiving S3 event notifications to be watched instead of polling"`
+
+	BaseDir string `long:"base-dir" description:"Directory where a directory for each domain is created for file target" default:"."`
+	Owner   string `long:"owner" description:"Owner of the files for file target (name or uid)"`
+	Group   string `long:"group" description:"Group of the files for file target (name or gid)"`
+	Mode    string `long:"mode" description:"Permission of the files in octal for file target" default:"0600"`
+
+	PasswordFile string `long:"password-file" description:"File containing the password for pfx and jks formats"`
 
-func (c *SyncCommand) init(ctx context.Context) {
-	s3b := bucket.New(s3.NewFromConfig(MustNewAWSConfig(ctx)), Options.S3Bucket)
-	c.s3Filer = agent.NewS3Filer(s3b, "")
-	c.osFiler = &agent.OSFiler{BaseDir: c.Domain}
+	Namespace  string `long:"namespace" description:"Namespace of the Secret for k8s target" default:"default"`
+	SecretName string `long:"secret-name" description:"Name of the Secret for k8s target. It is derived from the domain if not set"`
+	Kubeconfig string `long:"kubeconfig" description:"Path to kubeconfig for k8s target. In-cluster config is used if not set" env:"KUBECONFIG"`
 }
 
 func (c *SyncCommand) Execute(args []string) error {
-	ctx := context.Background()
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
 
-	c.init(ctx)
+	if c.Watch && c.Interval <= 0 {
+		return errors.New("--interval must be positive")
+	}
+
+	store, err := NewStoreFromOptions(ctx)
+	if err != nil {
+		return fmt.Errorf("initializing the store: %w", err)
+	}
 
-	for _, fn := range []string{
-		"privkey.pem",
-		"cert.pem",
-	} {
-		key := c.s3Filer.Join("aaa-data", Options.Email, "domain", c.Domain, fn)
+	domains, err := c.selectDomains(ctx, store)
+	if err != nil {
+		return err
+	}
+
+	if c.SecretName != "" && len(domains) > 1 {
+		return errors.New("--secret-name can't be used with multiple domains")
+	}
+
+	newTarget, err := c.syncTarget()
+	if err != nil {
+		return err
+	}
+
+	services := make([]*SyncService, len(domains))
+	for i, dom := range domains {
+		services[i] = &SyncService{
+			Domain:   dom,
+			Store:    store,
+			Target:   newTarget(dom),
+			Optional: !slices.Contains(c.Domains, dom),
+		}
+	}
 
-		blob, err := c.s3Filer.ReadFile(ctx, key)
+	if c.Watch {
+		w := &SyncWatcher{
+			Services: services,
+			OnChange: c.runOnChange,
+			Interval: c.Interval,
+			QueueURL: c.SQSQueueURL,
+		}
+
+		if c.SQSQueueURL != "" {
+			w.SQSClient = sqs.NewFromConfig(MustNewAWSConfig(ctx))
+		}
+
+		return w.Run(ctx)
+	}
+
+	results := SyncAll(ctx, services, c.Concurrency, c.runOnChange)
+
+	return writeSyncSummary(os.Stdout, results)
+}
+
+// selectDomains returns the domains given by --domain, --domain-glob and --all. c.Domains are normalized.
+func (c *SyncCommand) selectDomains(ctx context.Context, store *agent.Store) ([]string, error) {
+	for i, dom := range c.Domains {
+		normalized, err := agent.NormalizeDomain(dom)
 		if err != nil {
-			log.Printf("aaa: failed to read '%s' data from S3: %s", fn, err)
-			return err
+			return nil, err
+		}
+
+		c.Domains[i] = normalized
+	}
+
+	domains := slices.Clone(c.Domains)
+
+	if c.All || len(c.DomainGlobs) > 0 {
+		stored, err := store.ListDomains(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("listing the domains: %w", err)
+		}
+
+		for _, dom := range stored {
+			ok, err := c.matchDomain(dom)
+			if err != nil {
+				return nil, err
+			}
+
+			if ok {
+				domains = append(domains, dom)
+			}
+		}
+	}
+
+	slices.Sort(domains)
+	domains = slices.Compact(domains)
+
+	if len(domains) == 0 {
+		return nil, errors.New("no domain is selected. specify --domain, --domain-glob or --all")
+	}
+
+	return domains, nil
+}
+
+func (c *SyncCommand) matchDomain(domain string) (bool, error) {
+	if c.All {
+		return true, nil
+	}
+
+	return matchGlobs(c.DomainGlobs, domain)
+}
+
+// matchGlobs reports whether the domain in A-labels or U-labels matches any of the glob patterns.
+// It returns true if globs is empty.
+func matchGlobs(globs []string, domain string) (bool, error) {
+	if len(globs) == 0 {
+		return true, nil
+	}
+
+	names := []string{domain}
+	if display := agent.DisplayDomain(domain); display != domain {
+		names = append(names, display)
+	}
+
+	for _, glob := range globs {
+		for _, name := range names {
+			ok, err := path.Match(glob, name)
+			if err != nil {
+				return false, fmt.Errorf("parsing --domain-glob '%s': %w", glob, err)
+			}
+
+			if ok {
+				return true, nil
+			}
+		}
+	}
+
+	return false, nil
+}
+
+func (c *SyncCommand) runOnChange(ctx context.Context, domain string) error {
+	if c.OnChange == "" {
+		return nil
+	}
+
+	cmd := exec.CommandContext(ctx, "sh", "-c", c.OnChange)
+	cmd.Env = append(os.Environ(), "AAA_DOMAIN="+domain)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("running the on-change command: %w", err)
+	}
+
+	return nil
+}
+
+// syncTarget returns a function that creates the target for each domain.
+func (c *SyncCommand) syncTarget() (func(string) SyncTarget, error) {
+	switch c.Target {
+	case "k8s":
+		if c.Format != agent.ExportFormatPEM {
+			return nil, errors.New("--format is only for file target")
+		}
+
+		client, err := NewKubernetesClient(c.Kubeconfig)
+		if err != nil {
+			return nil, fmt.Errorf("initializing the kubernetes client: %w", err)
+		}
+
+		return func(domain string) SyncTarget {
+			name := c.SecretName
+			if name == "" {
+				name = k8sSecretName(domain)
+			}
+
+			return &K8sSyncTarget{
+				Client:     client,
+				Namespace:  c.Namespace,
+				SecretName: name,
+			}
+		}, nil
+	default:
+		mode, err := strconv.ParseUint(c.Mode, 8, 32)
+		if err != nil {
+			return nil, fmt.Errorf("parsing --mode: %w", err)
+		}
+
+		uid, gid, err := lookupOwner(c.Owner, c.Group)
+		if err != nil {
+			return nil, err
+		}
+
+		password, err := readPassword(c.Format, c.PasswordFile)
+		if err != nil {
+			return nil, err
+		}
+
+		return func(domain string) SyncTarget {
+			return &FileSyncTarget{
+				// *.example.com is written into _wildcard.example.com so that the directory is safe in the shell
+				BaseDir: filepath.Join(c.BaseDir, agent.EncodeDomainPath(domain)),
+				Mode:    os.FileMode(mode),
+				UID:     uid,
+				GID:     gid,
+				Exporter: &agent.Exporter{
+					Format:   c.Format,
+					Password: password,
+					Alias:    domain,
+				},
+			}
+		}, nil
+	}
+}
+
+// lookupOwner resolves the owner and the group into uid and gid. It returns -1 for empty ones.
+func lookupOwner(owner, group string) (int, int, error) {
+	uid, gid := -1, -1
+
+	if owner != "" {
+		u, err := user.Lookup(owner)
+		if err != nil {
+			if u, err = user.LookupId(owner); err != nil {
+				return 0, 0, fmt.Errorf("looking up the owner '%s': %w", owner, err)
+			}
+		}
+
+		uid, _ = strconv.Atoi(u.Uid)
+	}
+
+	if group != "" {
+		g, err := user.LookupGroup(group)
+		if err != nil {
+			if g, err = user.LookupGroupId(group); err != nil {
+				return 0, 0, fmt.Errorf("looking up the group '%s': %w", group, err)
+			}
+		}
+
+		gid, _ = strconv.Atoi(g.Gid)
+	}
+
+	return uid, gid, nil
+}
+
+// SyncFiles is the certificate and its private key read from the store.
+type SyncFiles struct {
+	Bundle *agent.CertBundle
+	Key    crypto.PrivateKey
+
+	// PrivKey is the private key in PEM.
+	PrivKey []byte
+
+	// FullChain is the certificate and the intermediate certificates in PEM.
+	FullChain []byte
+}
+
+// SyncTarget is a destination that the certificate in the store is synced to.
+type SyncTarget interface {
+	// Sync writes the files and reports whether the content has been changed.
+	Sync(ctx context.Context, domain string, files *SyncFiles) (bool, error)
+}
+
+// SyncService reads the certificate from the store and syncs it to the target.
+type SyncService struct {
+	Domain string
+	Store  *agent.Store
+	Target SyncTarget
+
+	// Optional is set for the domains selected by --all or --domain-glob.
+	// They are skipped if the private key is not in the store.
+	Optional bool
+}
+
+// Run syncs the certificate and reports whether the content has been changed.
+func (svc *SyncService) Run(ctx context.Context) (bool, error) {
+	files, err := loadSyncFiles(ctx, svc.Store, svc.Domain)
+	if err != nil {
+		return false, err
+	}
+
+	changed, err := svc.Target.Sync(ctx, svc.Domain, files)
+	if err != nil {
+		return false, fmt.Errorf("syncing the certificate: %w", err)
+	}
+
+	slog.InfoContext(ctx, "synced", "email", svc.Store.Email(), "domain", svc.Domain, "changed", changed)
+
+	return changed, nil
+}
+
+// skippable reports whether the domain is skipped instead of failing by err.
+func (svc *SyncService) skippable(err error) bool {
+	return svc.Optional && errors.Is(err, errNoExportableKey)
+}
+
+// loadSyncFiles reads the certificate and its private key for the domain from the store.
+func loadSyncFiles(ctx context.Context, store *agent.Store, domain string) (*SyncFiles, error) {
+	bundle, privKey, err := loadCertBundle(ctx, store, domain)
+	if err != nil {
+		return nil, err
+	}
+
+	// it has been verified by loadCertBundle
+	key, err := certcrypto.ParsePEMPrivateKey(privKey)
+	if err != nil {
+		return nil, fmt.Errorf("parsing the private key: %w", err)
+	}
+
+	return &SyncFiles{
+		Bundle:    bundle,
+		Key:       key,
+		PrivKey:   privKey,
+		FullChain: bundle.FullChainPEM(),
+	}, nil
+}
+
+// SyncResult is the result of the sync for the domain.
+type SyncResult struct {
+	Domain  string
+	Changed bool
+	Skipped bool
+	Err     error
+}
+
+// SyncAll syncs the domains concurrently and calls onChange for the domains that have been changed.
+func SyncAll(ctx context.Context, services []*SyncService, concurrency int, onChange func(context.Context, string) error) []SyncResult {
+	results := make([]SyncResult, len(services))
+	queue := make(chan int)
+
+	var wg sync.WaitGroup
+	for range max(concurrency, 1) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			for i := range queue {
+				svc := services[i]
+
+				changed, err := svc.Run(ctx)
+				if err == nil && changed {
+					err = onChange(ctx, svc.Domain)
+				}
+
+				skipped := svc.skippable(err)
+				switch {
+				case skipped:
+					slog.WarnContext(ctx, "skipping the domain", "domain", svc.Domain, "reason", err)
+					err = nil
+				case err != nil:
+					slog.ErrorContext(ctx, "failed to sync", "domain", svc.Domain, "error", err)
+				}
+
+				results[i] = SyncResult{Domain: svc.Domain, Changed: changed, Skipped: skipped, Err: err}
+			}
+		}()
+	}
+
+	for i := range services {
+		queue <- i
+	}
+
+	close(queue)
+	wg.Wait()
+
+	return results
+}
+
+// writeSyncSummary writes the summary of the results. It returns an error if any domain has been failed.
+func writeSyncSummary(w io.Writer, results []SyncResult) error {
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+
+	var changed, skipped, failed int
+	for _, r := range results {
+		status := "unchanged"
+		switch {
+		case r.Err != nil:
+			status = "failed: " + r.Err.Error()
+			failed++
+		case r.Skipped:
+			status = "skipped: no exportable key"
+			skipped++
+		case r.Changed:
+			status = "changed"
+			changed++
+		}
+
+		fmt.Fprintf(tw, "%s\t%s\n", r.Domain, status)
+	}
+
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+
+	fmt.Fprintf(w, "%d domains: %d changed, %d unchanged, %d skipped, %d failed\n", len(results), changed, len(results)-changed-skipped-failed, skipped, failed)
+
+	if failed > 0 {
+		return fmt.Errorf("aaa: failed to sync %d domains", failed)
+	}
+
+	return nil
+}
+
+// syncDataDir is the symlink to the directory of the current version of the files.
+// The files in BaseDir are the symlinks into it so that all of them are replaced at once by swapping syncDataDir.
+const syncDataDir = "..data"
+
+// FileSyncTarget writes the files exported by Exporter into BaseDir.
+// The files are written into a new version directory and swapped atomically by the symlink
+// so that readers never see partially written files or the private key that does not match the certificate.
+type FileSyncTarget struct {
+	BaseDir  string
+	Mode     os.FileMode
+	Exporter *agent.Exporter
+
+	// UID and GID are the owner of the files. -1 keeps the current owner.
+	UID int
+	GID int
+}
+
+func (t *FileSyncTarget) Sync(ctx context.Context, domain string, files *SyncFiles) (bool, error) {
+	// --base-dir holds only the directories for the domains
+	if err := os.MkdirAll(filepath.Dir(t.BaseDir), 0755); err != nil {
+		return false, err
+	}
+
+	if err := os.Mkdir(t.BaseDir, t.dirMode()); err != nil && !os.IsExist(err) {
+		return false, err
+	}
+
+	// --mode, --owner and --group are applied to the directory as well so that the readers can traverse it
+	if err := t.chmodChown(t.BaseDir, t.dirMode()); err != nil {
+		return false, err
+	}
+
+	exported, err := t.Exporter.Export(files.Bundle, files.Key)
+	if err != nil {
+		return false, err
+	}
+
+	changed := false
+	for _, f := range exported {
+		current, err := os.ReadFile(filepath.Join(t.BaseDir, f.Name))
+		if err != nil && !os.IsNotExist(err) {
+			return false, err
 		}
 
-		if err := c.osFiler.WriteFile(ctx, fn, blob); err != nil {
-			log.Printf("aaa: failed to write '%s' data: %s", fn, err)
+		if !t.Exporter.Match(f, current, files.Bundle, files.Key) {
+			changed = true
+			break
+		}
+	}
+
+	// the files written by the older versions are moved into syncDataDir without reporting the change
+	_, err = os.Lstat(filepath.Join(t.BaseDir, syncDataDir))
+	if err != nil && !os.IsNotExist(err) {
+		return false, err
+	}
+
+	if !changed && err == nil {
+		// --mode, --owner and --group may have been changed even if the content is the same
+		return false, t.applyPermissions(exported)
+	}
+
+	if err := t.replace(exported); err != nil {
+		return false, err
+	}
+
+	return changed, nil
+}
+
+func (t *FileSyncTarget) applyPermissions(exported []agent.ExportFile) error {
+	// the version directory is reached via the symlink
+	if err := t.chmodChown(filepath.Join(t.BaseDir, syncDataDir), t.dirMode()); err != nil {
+		return err
+	}
+
+	for _, f := range exported {
+		if err := t.chmodChown(filepath.Join(t.BaseDir, f.Name), t.Mode); err != nil {
 			return err
 		}
+	}
+
+	return nil
+}
+
+// dirMode returns the permission of the directories that allows the readers of the files to traverse them.
+func (t *FileSyncTarget) dirMode() os.FileMode {
+	read := t.Mode & 0044
+	return 0700 | read | read>>2
+}
+
+func (t *FileSyncTarget) chmodChown(path string, mode os.FileMode) error {
+	if err := os.Chmod(path, mode); err != nil {
+		return err
+	}
+
+	if t.UID != -1 || t.GID != -1 {
+		return os.Chown(path, t.UID, t.GID)
+	}
+
+	return nil
+}
+
+// replace writes all the files into a new version directory and swaps syncDataDir to it.
+func (t *FileSyncTarget) replace(exported []agent.ExportFile) error {
+	version, err := os.MkdirTemp(t.BaseDir, syncDataDir+"-")
+	if err != nil {
+		return err
+	}
+
+	swapped := false
+	defer func() {
+		if !swapped {
+			os.RemoveAll(version)
+		}
+	}()
+
+	if err := t.chmodChown(version, t.dirMode()); err != nil {
+		return err
+	}
+
+	for _, f := range exported {
+		if err := t.writeFile(filepath.Join(version, f.Name), f.Data); err != nil {
+			return fmt.Errorf("writing '%s': %w", f.Name, err)
+		}
+	}
+
+	if err := replaceSymlink(filepath.Base(version), filepath.Join(t.BaseDir, syncDataDir)); err != nil {
+		return fmt.Errorf("swapping the files: %w", err)
+	}
+
+	swapped = true
+
+	// the files written by the older versions are replaced by the symlinks one by one only for the first time
+	for _, f := range exported {
+		link := filepath.Join(syncDataDir, f.Name)
+		path := filepath.Join(t.BaseDir, f.Name)
+
+		if cur, err := os.Readlink(path); err == nil && cur == link {
+			continue
+		}
+
+		if err := replaceSymlink(link, path); err != nil {
+			return fmt.Errorf("replacing '%s': %w", f.Name, err)
+		}
+	}
+
+	// removing the symlinks dangling after --format is changed
+	entries, err := os.ReadDir(t.BaseDir)
+	if err != nil {
+		return err
+	}
+
+	for _, e := range entries {
+		path := filepath.Join(t.BaseDir, e.Name())
+		if cur, err := os.Readlink(path); err == nil && filepath.Dir(cur) == syncDataDir && !slices.ContainsFunc(exported, func(f agent.ExportFile) bool {
+			return f.Name == e.Name()
+		}) {
+			os.Remove(path)
+		}
+	}
 
-		log.Printf("aaa: %s synced", fn)
+	// removing the previous versions including the ones left by the interrupted sync
+	dirs, err := filepath.Glob(filepath.Join(t.BaseDir, syncDataDir+"-*"))
+	if err != nil {
+		return err
+	}
+
+	for _, dir := range dirs {
+		if dir != version {
+			os.RemoveAll(dir)
+		}
 	}
 
 	return nil
 }
+
+func (t *FileSyncTarget) writeFile(path string, data []byte) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, t.Mode)
+	if err != nil {
+		return err
+	}
+
+	defer f.Close()
+
+	if err := f.Chmod(t.Mode); err != nil {
+		return err
+	}
+
+	if t.UID != -1 || t.GID != -1 {
+		if err := f.Chown(t.UID, t.GID); err != nil {
+			return err
+		}
+	}
+
+	if _, err := f.Write(data); err != nil {
+		return err
+	}
+
+	if err := f.Sync(); err != nil {
+		return err
+	}
+
+	return f.Close()
+}
+
+// replaceSymlink atomically replaces path with the symlink to target.
+func replaceSymlink(target, path string) error {
+	tmp := path + ".tmp-link"
+
+	if err := os.Remove(tmp); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	if err := os.Symlink(target, tmp); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, path)
+}
@@ -0,0 +1,217 @@
+package command
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/go-acme/lego/v4/certcrypto"
+	"github.com/nabeken/aaa/v3/agent"
+)
+
+// newTestSyncFiles returns the self-signed certificate for example.com and its private key.
+func newTestSyncFiles(t *testing.T) *SyncFiles {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "example.com"},
+		DNSNames:     []string{"example.com"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(24 * time.Hour),
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, key.Public(), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	bundle, err := agent.ParseCertBundle(certcrypto.PEMEncode(certcrypto.DERCertificateBytes(der)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &SyncFiles{Bundle: bundle, Key: key, PrivKey: certcrypto.PEMEncode(key), FullChain: bundle.FullChainPEM()}
+}
+
+func newTestFileSyncTarget(t *testing.T, format string, mode os.FileMode) *FileSyncTarget {
+	t.Helper()
+
+	return &FileSyncTarget{
+		BaseDir:  filepath.Join(t.TempDir(), "example.com"),
+		Mode:     mode,
+		Exporter: &agent.Exporter{Format: format},
+		UID:      -1,
+		GID:      -1,
+	}
+}
+
+// assertSyncedFiles ensures that the files in BaseDir are exactly names and they are the symlinks into syncDataDir.
+func assertSyncedFiles(t *testing.T, target *FileSyncTarget, names ...string) {
+	t.Helper()
+
+	var links []string
+	entries, err := os.ReadDir(target.BaseDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, e := range entries {
+		if e.Type()&os.ModeSymlink != 0 && e.Name() != syncDataDir {
+			links = append(links, e.Name())
+		}
+	}
+
+	names = slices.Sorted(slices.Values(names))
+	if !slices.Equal(links, names) {
+		t.Errorf("symlinks = %v, want %v", links, names)
+	}
+
+	for _, name := range names {
+		if cur, err := os.Readlink(filepath.Join(target.BaseDir, name)); err != nil || cur != filepath.Join(syncDataDir, name) {
+			t.Errorf("Readlink(%s) = %q, %v", name, cur, err)
+		}
+	}
+
+	versions, err := filepath.Glob(filepath.Join(target.BaseDir, syncDataDir+"-*"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(versions) != 1 {
+		t.Errorf("version directories = %v, want one", versions)
+	}
+}
+
+func assertMode(t *testing.T, path string, want os.FileMode) {
+	t.Helper()
+
+	fi, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := fi.Mode().Perm(); got != want {
+		t.Errorf("mode of %s = %o, want %o", filepath.Base(path), got, want)
+	}
+}
+
+var testPEMFiles = []string{"privkey.pem", "cert.pem", "leaf.pem", "chain.pem", "fullchain.pem"}
+
+func TestFileSyncTargetOverPlainFiles(t *testing.T) {
+	ctx := context.Background()
+	files := newTestSyncFiles(t)
+	target := newTestFileSyncTarget(t, agent.ExportFormatPEM, 0600)
+
+	exported, err := target.Exporter.Export(files.Bundle, files.Key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the files written by the older versions and the file not managed by sync
+	if err := os.MkdirAll(target.BaseDir, 0700); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, f := range append(exported, agent.ExportFile{Name: "dhparam.pem", Data: []byte("dhparam")}) {
+		if err := os.WriteFile(filepath.Join(target.BaseDir, f.Name), f.Data, 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	changed, err := target.Sync(ctx, "example.com", files)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if changed {
+		t.Error("changed = true, want false for the same content")
+	}
+
+	assertSyncedFiles(t, target, testPEMFiles...)
+
+	for _, f := range exported {
+		if got, err := os.ReadFile(filepath.Join(target.BaseDir, f.Name)); err != nil || !slices.Equal(got, f.Data) {
+			t.Errorf("%s = %q, %v, want the exported content", f.Name, got, err)
+		}
+	}
+
+	if got, err := os.ReadFile(filepath.Join(target.BaseDir, "dhparam.pem")); err != nil || string(got) != "dhparam" {
+		t.Errorf("dhparam.pem = %q, %v, want it untouched", got, err)
+	}
+}
+
+func TestFileSyncTargetUnchanged(t *testing.T) {
+	ctx := context.Background()
+	files := newTestSyncFiles(t)
+	target := newTestFileSyncTarget(t, agent.ExportFormatPEM, 0600)
+
+	changed, err := target.Sync(ctx, "example.com", files)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !changed {
+		t.Error("changed = false, want true for the first sync")
+	}
+
+	// --mode is changed while the content is the same
+	target.Mode = 0640
+
+	changed, err = target.Sync(ctx, "example.com", files)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if changed {
+		t.Error("changed = true, want false for the same content")
+	}
+
+	assertSyncedFiles(t, target, testPEMFiles...)
+
+	for _, name := range testPEMFiles {
+		assertMode(t, filepath.Join(target.BaseDir, name), 0640)
+	}
+
+	// the readers in the group must traverse the directories
+	assertMode(t, target.BaseDir, 0750)
+	assertMode(t, filepath.Join(target.BaseDir, syncDataDir), 0750)
+}
+
+func TestFileSyncTargetFormatSwitch(t *testing.T) {
+	ctx := context.Background()
+	files := newTestSyncFiles(t)
+	target := newTestFileSyncTarget(t, agent.ExportFormatPEM, 0600)
+
+	if _, err := target.Sync(ctx, "example.com", files); err != nil {
+		t.Fatal(err)
+	}
+
+	target.Exporter = &agent.Exporter{Format: agent.ExportFormatDER}
+
+	changed, err := target.Sync(ctx, "example.com", files)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !changed {
+		t.Error("changed = false, want true for the new format")
+	}
+
+	// the symlinks for PEM would be dangling if they were left
+	assertSyncedFiles(t, target, "privkey.der", "cert.der")
+}
@@ -0,0 +1,226 @@
+package command
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/arn"
+	"github.com/aws/aws-sdk-go-v2/service/apigatewayv2"
+	apigwtypes "github.com/aws/aws-sdk-go-v2/service/apigatewayv2/types"
+	elbv2 "github.com/aws/aws-sdk-go-v2/service/elasticloadbalancingv2"
+	elbv2types "github.com/aws/aws-sdk-go-v2/service/elasticloadbalancingv2/types"
+	"github.com/aws/aws-sdk-go-v2/service/iam"
+	iamtypes "github.com/aws/aws-sdk-go-v2/service/iam/types"
+	"github.com/nabeken/aaa/v3/agent"
+)
+
+// UploadTarget is a destination that the certificate in the store is uploaded to.
+type UploadTarget interface {
+	// Upload uploads the certificate for the domain and returns the ARNs of the uploaded certificates.
+	Upload(ctx context.Context, store *agent.Store, domain string) ([]string, error)
+}
+
+type multiTarget []UploadTarget
+
+// multiUploadTarget returns UploadTarget that uploads to the target for each resource.
+func multiUploadTarget(resources []string, newTarget func(string) UploadTarget) UploadTarget {
+	targets := make(multiTarget, len(resources))
+	for i, r := range resources {
+		targets[i] = newTarget(r)
+	}
+
+	return targets
+}
+
+func (ts multiTarget) Upload(ctx context.Context, store *agent.Store, domain string) ([]string, error) {
+	var arns []string
+	for _, t := range ts {
+		a, err := t.Upload(ctx, store, domain)
+		if err != nil {
+			return nil, err
+		}
+
+		arns = append(arns, a...)
+	}
+
+	return arns, nil
+}
+
+// ACMUploadTarget imports the certificate into ACM in each target.
+type ACMUploadTarget struct {
+	Targets []agent.ACMTarget
+	NewARN  bool
+}
+
+func (t *ACMUploadTarget) Upload(ctx context.Context, store *agent.Store, domain string) ([]string, error) {
+	return UploadToACMTargets(ctx, store, domain, t.Targets, t.NewARN)
+}
+
+// IAMUploadTarget uploads the certificate as an IAM server certificate for legacy ELB and CloudFront.
+type IAMUploadTarget struct {
+	// Path must be "/cloudfront/" for CloudFront.
+	Path string
+
+	Target agent.ACMTarget
+}
+
+func (t *IAMUploadTarget) Upload(ctx context.Context, store *agent.Store, domain string) ([]string, error) {
+	bundle, privKey, err := loadCertBundle(ctx, store, domain)
+	if err != nil {
+		return nil, err
+	}
+
+	client := iam.NewFromConfig(MustNewAWSConfigForTarget(ctx, t.Target))
+
+	// IAM server certificate can't be overwritten so the name is unique per certificate
+	name := iamServerCertificateName(domain, bundle.Leaf.SerialNumber.Text(16))
+
+	resp, err := client.UploadServerCertificate(ctx, &iam.UploadServerCertificateInput{
+		ServerCertificateName: aws.String(name),
+		Path:                  aws.String(t.Path),
+		CertificateBody:       aws.String(string(bundle.LeafPEM())),
+		CertificateChain:      aws.String(string(bundle.ChainPEM())),
+		PrivateKey:            aws.String(string(privKey)),
+	})
+
+	var existsErr *iamtypes.EntityAlreadyExistsException
+	if errors.As(err, &existsErr) {
+		slog.InfoContext(ctx, "the certificate has been uploaded to IAM already", "domain", domain, "name", name)
+
+		existing, err := client.GetServerCertificate(ctx, &iam.GetServerCertificateInput{
+			ServerCertificateName: aws.String(name),
+		})
+		if err != nil {
+			return nil, fmt.Errorf("getting the existing server certificate: %w", err)
+		}
+
+		return []string{aws.ToString(existing.ServerCertificate.ServerCertificateMetadata.Arn)}, nil
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("uploading to IAM: %w", err)
+	}
+
+	return []string{aws.ToString(resp.ServerCertificateMetadata.Arn)}, nil
+}
+
+// iamServerCertificateName returns the name of IAM server certificate.
+// It must be up to 128 characters of [\w+=,.@-].
+func iamServerCertificateName(domain, serial string) string {
+	name := strings.ReplaceAll(domain, "*", "wildcard")
+	if maxLen := 128 - len(serial) - 1; len(name) > maxLen {
+		name = name[:maxLen]
+	}
+
+	return name + "-" + serial
+}
+
+// APIGatewayUploadTarget imports the certificate into ACM and rebinds API Gateway custom domain to it.
+type APIGatewayUploadTarget struct {
+	DomainName string
+	Target     agent.ACMTarget
+}
+
+func (t *APIGatewayUploadTarget) Upload(ctx context.Context, store *agent.Store, domain string) ([]string, error) {
+	arns, err := UploadToACMTargets(ctx, store, domain, []agent.ACMTarget{t.Target}, false)
+	if err != nil {
+		return nil, err
+	}
+
+	certARN := arns[0]
+	client := apigatewayv2.NewFromConfig(MustNewAWSConfigForTarget(ctx, t.Target))
+
+	current, err := client.GetDomainName(ctx, &apigatewayv2.GetDomainNameInput{
+		DomainName: aws.String(t.DomainName),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("getting the custom domain '%s': %w", t.DomainName, err)
+	}
+
+	rebind := false
+	configs := make([]apigwtypes.DomainNameConfiguration, len(current.DomainNameConfigurations))
+	for i, c := range current.DomainNameConfigurations {
+		if aws.ToString(c.CertificateArn) != certARN {
+			rebind = true
+		}
+
+		configs[i] = apigwtypes.DomainNameConfiguration{
+			CertificateArn:                      aws.String(certARN),
+			EndpointType:                        c.EndpointType,
+			SecurityPolicy:                      c.SecurityPolicy,
+			IpAddressType:                       c.IpAddressType,
+			OwnershipVerificationCertificateArn: c.OwnershipVerificationCertificateArn,
+		}
+	}
+
+	// the certificate is renewed in place
+	if !rebind {
+		return arns, nil
+	}
+
+	if _, err := client.UpdateDomainName(ctx, &apigatewayv2.UpdateDomainNameInput{
+		DomainName:               aws.String(t.DomainName),
+		DomainNameConfigurations: configs,
+	}); err != nil {
+		return nil, fmt.Errorf("updating the custom domain '%s': %w", t.DomainName, err)
+	}
+
+	slog.InfoContext(ctx, "API Gateway custom domain has been rebound", "domain", domain, "custom_domain", t.DomainName, "arn", certARN)
+
+	return arns, nil
+}
+
+// ALBUploadTarget imports the certificate into ACM and swaps the default certificate of ALB listener to it.
+type ALBUploadTarget struct {
+	ListenerARN string
+
+	// RoleARN is assumed to access the listener in another account.
+	RoleARN string
+}
+
+func (t *ALBUploadTarget) Upload(ctx context.Context, store *agent.Store, domain string) ([]string, error) {
+	listenerARN, err := arn.Parse(t.ListenerARN)
+	if err != nil {
+		return nil, fmt.Errorf("parsing the listener ARN: %w", err)
+	}
+
+	// the certificate must be in the same region as the listener
+	target := agent.ACMTarget{Region: listenerARN.Region, RoleARN: t.RoleARN}
+
+	arns, err := UploadToACMTargets(ctx, store, domain, []agent.ACMTarget{target}, false)
+	if err != nil {
+		return nil, err
+	}
+
+	certARN := arns[0]
+	client := elbv2.NewFromConfig(MustNewAWSConfigForTarget(ctx, target))
+
+	resp, err := client.DescribeListenerCertificates(ctx, &elbv2.DescribeListenerCertificatesInput{
+		ListenerArn: aws.String(t.ListenerARN),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("describing the listener certificates: %w", err)
+	}
+
+	for _, cert := range resp.Certificates {
+		// the certificate is renewed in place
+		if aws.ToBool(cert.IsDefault) && aws.ToString(cert.CertificateArn) == certARN {
+			return arns, nil
+		}
+	}
+
+	if _, err := client.ModifyListener(ctx, &elbv2.ModifyListenerInput{
+		ListenerArn:  aws.String(t.ListenerARN),
+		Certificates: []elbv2types.Certificate{{CertificateArn: aws.String(certARN)}},
+	}); err != nil {
+		return nil, fmt.Errorf("swapping the listener certificate: %w", err)
+	}
+
+	slog.InfoContext(ctx, "ALB listener certificate has been swapped", "domain", domain, "listener_arn", t.ListenerARN, "arn", certARN)
+
+	return arns, nil
+}
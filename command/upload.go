@@ -2,24 +2,27 @@ package command
 
 import (
 	"context"
-	"crypto/x509"
-	"encoding/pem"
+	"errors"
 	"fmt"
-	"log"
+	"log/slog"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/service/acm"
-	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/acm/types"
 	"github.com/go-acme/lego/v4/certcrypto"
 	"github.com/nabeken/aaa/v3/agent"
-	"github.com/nabeken/aws-go-s3/v2/bucket"
 )
 
 type UploadService struct {
 	Domain string
-	Email  string
 
-	S3Filer   *agent.S3Filer
+	// NewARN imports the certificate as a new ACM certificate even if it has been imported before.
+	NewARN bool
+
+	// Target is used to track the ARN. ACMClient must be initialized for the target.
+	Target agent.ACMTarget
+
+	Store     *agent.Store
 	ACMClient *acm.Client
 }
 
@@ -30,77 +33,271 @@ aws acm import-certificate \
     --private-key file://PrivateKey.pem
 */
 
-func (svc *UploadService) get(ctx context.Context, key string) ([]byte, error) {
-	fn := svc.S3Filer.Join("aaa-data", "v2", svc.Email, "domain", svc.Domain, key)
-
-	blob, err := svc.S3Filer.ReadFile(ctx, fn)
+func (svc *UploadService) buildImportCertificateInput(ctx context.Context) (*acm.ImportCertificateInput, error) {
+	bundle, privKey, err := loadCertBundle(ctx, svc.Store, svc.Domain)
 	if err != nil {
-		return nil, fmt.Errorf("reading '%s': %w", fn, err)
+		return nil, err
 	}
 
-	return blob, nil
+	return &acm.ImportCertificateInput{
+		Certificate:      bundle.LeafPEM(),
+		PrivateKey:       privKey,
+		CertificateChain: bundle.ChainPEM(),
+	}, nil
 }
 
-func (svc *UploadService) buildImportCertificateInput(ctx context.Context) (*acm.ImportCertificateInput, error) {
-	privKey, err := svc.get(ctx, "privkey.pem")
+// errNoExportableKey is returned for the domains whose private key is not in the store,
+// i.e. the key is kept in KMS or PKCS#11 token, or the certificate is issued for the CSR.
+var errNoExportableKey = errors.New("aaa: no exportable key in the store")
+
+// loadCertBundle loads the certificate with the complete chain and its private key in PEM.
+// It ensures that the private key matches the certificate.
+func loadCertBundle(ctx context.Context, store *agent.Store, domain string) (*agent.CertBundle, []byte, error) {
+	privKey, err := store.LoadCertKeyPEM(ctx, domain)
+	if err == agent.ErrFileNotFound && hasNoExportableKey(ctx, store, domain) {
+		return nil, nil, errNoExportableKey
+	}
+
 	if err != nil {
-		return nil, err
+		return nil, nil, fmt.Errorf("reading the private key: %w", err)
 	}
 
-	cert, err := svc.get(ctx, "cert.pem")
+	cert, err := store.LoadCertPEM(ctx, domain)
 	if err != nil {
-		return nil, err
+		return nil, nil, fmt.Errorf("reading the certificate: %w", err)
 	}
 
-	certs, err := certcrypto.ParsePEMBundle(cert)
+	bundle, err := agent.ParseCertBundle(cert)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
-	return &acm.ImportCertificateInput{
-		Certificate:      svc.pemEncode(certs[0]),
-		PrivateKey:       privKey,
-		CertificateChain: svc.pemEncode(certs[1]),
-	}, nil
+	// the certificate may be issued without --bundle-ca
+	if err := bundle.CompleteChain(ctx); err != nil {
+		return nil, nil, err
+	}
+
+	key, err := certcrypto.ParsePEMPrivateKey(privKey)
+	if err != nil {
+		return nil, nil, fmt.Errorf("parsing the private key: %w", err)
+	}
+
+	if err := bundle.VerifyKey(key); err != nil {
+		return nil, nil, err
+	}
+
+	return bundle, privKey, nil
 }
 
-func (svc *UploadService) pemEncode(cert *x509.Certificate) []byte {
-	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw})
+// hasNoExportableKey reports whether the domain has the key reference or the CSR instead of the private key.
+func hasNoExportableKey(ctx context.Context, store *agent.Store, domain string) bool {
+	if _, err := store.LoadKeyRef(ctx, domain); err == nil {
+		return true
+	}
+
+	_, err := store.LoadCSR(ctx, domain)
+
+	return err == nil
 }
 
+// Run imports the certificate into ACM and returns its ARN.
+// If the certificate has been imported before, it is re-imported into the same ARN
+// so that the listeners that use it don't need to be updated.
 func (svc *UploadService) Run(ctx context.Context) (string, error) {
 	req, err := svc.buildImportCertificateInput(ctx)
 	if err != nil {
 		return "", fmt.Errorf("building an import request: %w", err)
 	}
 
+	md, err := svc.Store.LoadMetadata(ctx, svc.Domain)
+	if err != nil {
+		return "", fmt.Errorf("loading the metadata: %w", err)
+	}
+
+	if arn := md.ACMARN(svc.Target); !svc.NewARN && arn != "" {
+		req.CertificateArn = aws.String(arn)
+	}
+
 	resp, err := svc.ACMClient.ImportCertificate(ctx, req)
+
+	var notFoundErr *types.ResourceNotFoundException
+	if req.CertificateArn != nil && errors.As(err, &notFoundErr) {
+		slog.WarnContext(ctx, "the certificate has been deleted from ACM. importing as a new certificate...",
+			"email", svc.Store.Email(), "domain", svc.Domain, "arn", aws.ToString(req.CertificateArn))
+
+		req.CertificateArn = nil
+		resp, err = svc.ACMClient.ImportCertificate(ctx, req)
+	}
+
 	if err != nil {
 		return "", fmt.Errorf("importing into ACM: %w", err)
 	}
 
-	return aws.ToString(resp.CertificateArn), nil
+	arn := aws.ToString(resp.CertificateArn)
+
+	if md.ACMARN(svc.Target) != arn {
+		md.SetACMARN(svc.Target, arn)
+		if err := svc.Store.SaveMetadata(ctx, svc.Domain, md); err != nil {
+			return "", fmt.Errorf("saving the ARN into the metadata: %w", err)
+		}
+	}
+
+	return arn, nil
+}
+
+// UploadToACMTargets uploads the certificate into each target and returns the ARNs in the same order.
+// If targets is empty, the targets in the domain config are used.
+// The certificate is uploaded into the default region and account if neither is given.
+func UploadToACMTargets(ctx context.Context, store *agent.Store, domain string, targets []agent.ACMTarget, newARN bool) ([]string, error) {
+	if len(targets) == 0 {
+		cfg, err := store.LoadDomainConfig(ctx, domain)
+		if err != nil {
+			return nil, fmt.Errorf("loading the domain config: %w", err)
+		}
+
+		targets = cfg.ACMTargets
+	}
+
+	if len(targets) == 0 {
+		targets = []agent.ACMTarget{{}}
+	}
+
+	arns := make([]string, len(targets))
+	for i, target := range targets {
+		arn, err := (&UploadService{
+			Domain:    domain,
+			NewARN:    newARN,
+			Target:    target,
+			Store:     store,
+			ACMClient: NewACMClient(ctx, target),
+		}).Run(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("uploading to %s: %w", target.Key(), err)
+		}
+
+		slog.InfoContext(ctx, "certificate has been uploaded",
+			"email", store.Email(), "domain", domain, "region", target.Region, "role_arn", target.RoleARN, "arn", arn)
+
+		arns[i] = arn
+	}
+
+	return arns, nil
 }
 
 type UploadCommand struct {
-	Domain string `long:"domain" description:"Domain to be uploaded"`
+	Domain   string   `long:"domain" description:"Domain to be uploaded"`
+	Targets  []string `long:"target" description:"Where the certificate is uploaded to. Can be specified multiple times" choice:"acm" choice:"iam" choice:"apigateway" choice:"alb" default:"acm"`
+	NewARN   bool     `long:"new-arn" description:"Import as a new ACM certificate instead of re-importing into the existing ARN"`
+	Regions  []string `long:"region" description:"Region to be uploaded. Can be specified multiple times. The targets in the domain config are used if not set"`
+	RoleARNs []string `long:"role-arn" description:"Role to be assumed for each region. A single role is applied to all the regions"`
+
+	IAMPath           string   `long:"iam-path" description:"Path for IAM server certificate ('/cloudfront/' for CloudFront)" default:"/"`
+	APIGatewayDomains []string `long:"apigateway-domain" description:"API Gateway custom domain to be rebound. Can be specified multiple times"`
+	ALBListenerARNs   []string `long:"listener-arn" description:"ALB listener to swap the default certificate. Can be specified multiple times"`
 }
 
 func (c *UploadCommand) Execute(args []string) error {
 	ctx := context.Background()
-	cfg := MustNewAWSConfig(ctx)
-	s3b := bucket.New(s3.NewFromConfig(cfg), Options.S3Bucket)
-
-	arn, err := (&UploadService{
-		Domain:    c.Domain,
-		Email:     Options.Email,
-		S3Filer:   agent.NewS3Filer(s3b, ""),
-		ACMClient: acm.NewFromConfig(cfg),
-	}).Run(ctx)
+
+	domain, err := agent.NormalizeDomain(c.Domain)
+	if err != nil {
+		return err
+	}
+
+	c.Domain = domain
+
+	uploadTargets, err := c.uploadTargets()
 	if err != nil {
 		return err
 	}
 
-	log.Printf("INFO: certificate has been uploaded: %s", arn)
+	store, err := NewStoreFromOptions(ctx)
+	if err != nil {
+		return fmt.Errorf("initializing the store: %w", err)
+	}
+
+	for i, t := range uploadTargets {
+		arns, err := t.Upload(ctx, store, c.Domain)
+		if err != nil {
+			return err
+		}
+
+		slog.InfoContext(ctx, "certificate has been uploaded", "email", store.Email(), "domain", c.Domain, "target", c.Targets[i], "arns", arns)
+	}
+
 	return nil
 }
+
+// uploadTargets returns UploadTarget for each --target in the same order.
+func (c *UploadCommand) uploadTargets() ([]UploadTarget, error) {
+	acmTargets, err := c.targets()
+	if err != nil {
+		return nil, err
+	}
+
+	// IAM, API Gateway and ALB use the first region and role
+	var primary agent.ACMTarget
+	if len(acmTargets) > 0 {
+		primary = acmTargets[0]
+	}
+
+	var uploadTargets []UploadTarget
+
+	for _, name := range c.Targets {
+		switch name {
+		case "acm":
+			uploadTargets = append(uploadTargets, &ACMUploadTarget{Targets: acmTargets, NewARN: c.NewARN})
+		case "iam":
+			uploadTargets = append(uploadTargets, &IAMUploadTarget{Path: c.IAMPath, Target: primary})
+		case "apigateway":
+			if len(c.APIGatewayDomains) == 0 {
+				return nil, errors.New("--apigateway-domain is required for apigateway target")
+			}
+
+			uploadTargets = append(uploadTargets, multiUploadTarget(c.APIGatewayDomains, func(d string) UploadTarget {
+				return &APIGatewayUploadTarget{DomainName: d, Target: primary}
+			}))
+		case "alb":
+			if len(c.ALBListenerARNs) == 0 {
+				return nil, errors.New("--listener-arn is required for alb target")
+			}
+
+			uploadTargets = append(uploadTargets, multiUploadTarget(c.ALBListenerARNs, func(l string) UploadTarget {
+				return &ALBUploadTarget{ListenerARN: l, RoleARN: primary.RoleARN}
+			}))
+		}
+	}
+
+	return uploadTargets, nil
+}
+
+// targets pairs --region and --role-arn by their order.
+func (c *UploadCommand) targets() ([]agent.ACMTarget, error) {
+	if len(c.Regions) == 0 {
+		targets := make([]agent.ACMTarget, len(c.RoleARNs))
+		for i, role := range c.RoleARNs {
+			targets[i] = agent.ACMTarget{RoleARN: role}
+		}
+
+		return targets, nil
+	}
+
+	if len(c.RoleARNs) > 1 && len(c.RoleARNs) != len(c.Regions) {
+		return nil, errors.New("the number of --role-arn must be one or the same as --region")
+	}
+
+	targets := make([]agent.ACMTarget, len(c.Regions))
+	for i, region := range c.Regions {
+		targets[i].Region = region
+
+		switch len(c.RoleARNs) {
+		case 0:
+		case 1:
+			targets[i].RoleARN = c.RoleARNs[0]
+		default:
+			targets[i].RoleARN = c.RoleARNs[i]
+		}
+	}
+
+	return targets, nil
+}
@@ -0,0 +1,79 @@
+package command
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/nabeken/aaa/v3/agent"
+)
+
+func TestUploadCommandTargets(t *testing.T) {
+	const (
+		roleA = "arn:aws:iam::111111111111:role/aaa"
+		roleB = "arn:aws:iam::222222222222:role/aaa"
+	)
+
+	for _, tc := range []struct {
+		name     string
+		regions  []string
+		roleARNs []string
+		want     []agent.ACMTarget
+		wantErr  bool
+	}{
+		{
+			name: "nothing",
+			want: []agent.ACMTarget{},
+		},
+		{
+			name:    "regions only",
+			regions: []string{"us-east-1", "ap-northeast-1"},
+			want: []agent.ACMTarget{
+				{Region: "us-east-1"},
+				{Region: "ap-northeast-1"},
+			},
+		},
+		{
+			name:     "roles only",
+			roleARNs: []string{roleA, roleB},
+			want: []agent.ACMTarget{
+				{RoleARN: roleA},
+				{RoleARN: roleB},
+			},
+		},
+		{
+			name:     "single role for all the regions",
+			regions:  []string{"us-east-1", "ap-northeast-1"},
+			roleARNs: []string{roleA},
+			want: []agent.ACMTarget{
+				{Region: "us-east-1", RoleARN: roleA},
+				{Region: "ap-northeast-1", RoleARN: roleA},
+			},
+		},
+		{
+			name:     "paired by the order",
+			regions:  []string{"us-east-1", "ap-northeast-1"},
+			roleARNs: []string{roleA, roleB},
+			want: []agent.ACMTarget{
+				{Region: "us-east-1", RoleARN: roleA},
+				{Region: "ap-northeast-1", RoleARN: roleB},
+			},
+		},
+		{
+			name:     "mismatched number of roles",
+			regions:  []string{"us-east-1", "ap-northeast-1", "eu-west-1"},
+			roleARNs: []string{roleA, roleB},
+			wantErr:  true,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := (&UploadCommand{Regions: tc.regions, RoleARNs: tc.roleARNs}).targets()
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("targets() = %v, wantErr %v", err, tc.wantErr)
+			}
+
+			if !slices.Equal(got, tc.want) {
+				t.Errorf("targets() = %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
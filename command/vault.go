@@ -0,0 +1,43 @@
+package command
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	vault "github.com/hashicorp/vault/api"
+)
+
+// NewVaultClient initializes the Vault client.
+// The address and the token are taken from the standard VAULT_* environment variables.
+// If roleID is given, the client logs in with AppRole instead of the token.
+func NewVaultClient(ctx context.Context, roleID, secretID, approleMount string) (*vault.Client, error) {
+	client, err := vault.NewClient(vault.DefaultConfig())
+	if err != nil {
+		return nil, err
+	}
+
+	if roleID == "" {
+		if client.Token() == "" {
+			return nil, errors.New("aaa: please set VAULT_TOKEN or AppRole credentials")
+		}
+
+		return client, nil
+	}
+
+	secret, err := client.Logical().WriteWithContext(ctx, "auth/"+approleMount+"/login", map[string]any{
+		"role_id":   roleID,
+		"secret_id": secretID,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("logging in with AppRole: %w", err)
+	}
+
+	if secret == nil || secret.Auth == nil {
+		return nil, errors.New("aaa: no auth info returned from AppRole login")
+	}
+
+	client.SetToken(secret.Auth.ClientToken)
+
+	return client, nil
+}
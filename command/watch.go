@@ -0,0 +1,202 @@
+package command
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	sqstypes "github.com/aws/aws-sdk-go-v2/service/sqs/types"
+)
+
+// sqsRetryWait is the wait before receiving the messages again after the failure.
+const sqsRetryWait = 10 * time.Second
+
+// SyncWatcher re-syncs the certificates whenever they are updated in the store.
+type SyncWatcher struct {
+	Services []*SyncService
+
+	// OnChange is called for the domain after the content has been changed by the sync.
+	OnChange func(context.Context, string) error
+
+	// Interval is the interval to poll the version of the certificates.
+	Interval time.Duration
+
+	// SQSClient and QueueURL are used to receive S3 event notifications instead of polling.
+	SQSClient *sqs.Client
+	QueueURL  string
+}
+
+// Run watches the certificates until ctx is canceled.
+func (w *SyncWatcher) Run(ctx context.Context) error {
+	if w.QueueURL != "" {
+		slog.InfoContext(ctx, "watching the certificates via SQS", "domains", len(w.Services), "queue_url", w.QueueURL)
+
+		// the certificates may have been updated while the watcher is not running
+		for _, svc := range w.Services {
+			if err := w.sync(ctx, svc); err != nil {
+				slog.ErrorContext(ctx, "failed to sync", "domain", svc.Domain, "error", err)
+			}
+		}
+
+		return w.consume(ctx)
+	}
+
+	slog.InfoContext(ctx, "watching the certificates by polling", "domains", len(w.Services), "interval", w.Interval)
+
+	return w.poll(ctx)
+}
+
+func (w *SyncWatcher) sync(ctx context.Context, svc *SyncService) error {
+	changed, err := svc.Run(ctx)
+	if svc.skippable(err) {
+		slog.WarnContext(ctx, "skipping the domain", "domain", svc.Domain, "reason", err)
+		return nil
+	}
+
+	if err != nil {
+		return err
+	}
+
+	if changed && w.OnChange != nil {
+		return w.OnChange(ctx, svc.Domain)
+	}
+
+	return nil
+}
+
+func (w *SyncWatcher) poll(ctx context.Context) error {
+	ticker := time.NewTicker(w.Interval)
+	defer ticker.Stop()
+
+	lastVersions := make(map[string]string, len(w.Services))
+	for {
+		for _, svc := range w.Services {
+			logger := slog.Default().With("domain", svc.Domain)
+
+			version, err := svc.Store.CertVersion(ctx, svc.Domain)
+			if err != nil {
+				logger.ErrorContext(ctx, "failed to get the version of the certificate", "error", err)
+				continue
+			}
+
+			if version == lastVersions[svc.Domain] {
+				continue
+			}
+
+			logger.InfoContext(ctx, "the certificate has been updated", "version", version)
+
+			// the sync will be retried on the next tick if it fails
+			if err := w.sync(ctx, svc); err != nil {
+				logger.ErrorContext(ctx, "failed to sync", "error", err)
+				continue
+			}
+
+			lastVersions[svc.Domain] = version
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+func (w *SyncWatcher) consume(ctx context.Context) error {
+	logger := slog.Default().With("queue_url", w.QueueURL)
+
+	for {
+		resp, err := w.SQSClient.ReceiveMessage(ctx, &sqs.ReceiveMessageInput{
+			QueueUrl:            aws.String(w.QueueURL),
+			MaxNumberOfMessages: 10,
+			WaitTimeSeconds:     20,
+		})
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+
+			logger.ErrorContext(ctx, "failed to receive the messages", "error", err)
+
+			if !sleepContext(ctx, sqsRetryWait) {
+				return nil
+			}
+
+			continue
+		}
+
+		if len(resp.Messages) == 0 {
+			continue
+		}
+
+		keys := make(map[string]bool)
+		for _, msg := range resp.Messages {
+			for _, key := range s3EventKeys(aws.ToString(msg.Body)) {
+				keys[key] = true
+			}
+		}
+
+		failed := false
+		for _, svc := range w.Services {
+			if !keys[svc.Store.DomainPath(svc.Domain, "cert.pem")] && !keys[svc.Store.DomainPath(svc.Domain, "privkey.pem")] {
+				continue
+			}
+
+			logger.InfoContext(ctx, "the certificate has been updated", "domain", svc.Domain)
+
+			if err := w.sync(ctx, svc); err != nil {
+				logger.ErrorContext(ctx, "failed to sync", "domain", svc.Domain, "error", err)
+				failed = true
+			}
+		}
+
+		// the messages are redelivered after the visibility timeout
+		if failed {
+			continue
+		}
+
+		entries := make([]sqstypes.DeleteMessageBatchRequestEntry, len(resp.Messages))
+		for i, msg := range resp.Messages {
+			entries[i] = sqstypes.DeleteMessageBatchRequestEntry{
+				Id:            msg.MessageId,
+				ReceiptHandle: msg.ReceiptHandle,
+			}
+		}
+
+		if _, err := w.SQSClient.DeleteMessageBatch(ctx, &sqs.DeleteMessageBatchInput{
+			QueueUrl: aws.String(w.QueueURL),
+			Entries:  entries,
+		}); err != nil {
+			logger.ErrorContext(ctx, "failed to delete the messages", "error", err)
+		}
+	}
+}
+
+// s3EventKeys returns the object keys in the S3 event notification in body.
+func s3EventKeys(body string) []string {
+	// unwrapping the notification delivered via SNS without raw message delivery
+	var envelope struct {
+		Type    string
+		Message string
+	}
+
+	if err := json.Unmarshal([]byte(body), &envelope); err == nil && envelope.Type == "Notification" {
+		body = envelope.Message
+	}
+
+	var event events.S3Event
+	if err := json.Unmarshal([]byte(body), &event); err != nil {
+		return nil
+	}
+
+	keys := make([]string, len(event.Records))
+	for i, r := range event.Records {
+		keys[i] = r.S3.Object.URLDecodedKey
+	}
+
+	return keys
+}
@@ -0,0 +1,41 @@
+package command
+
+import (
+	"encoding/json"
+	"slices"
+	"testing"
+)
+
+func TestS3EventKeys(t *testing.T) {
+	const event = `{"Records":[` +
+		`{"s3":{"bucket":{"name":"b"},"object":{"key":"aaa-data/v2/you%40example.com/domain/example.com/cert.pem"}}},` +
+		`{"s3":{"bucket":{"name":"b"},"object":{"key":"aaa-data/v2/you%40example.com/domain/_wildcard.example.com/privkey.pem"}}}` +
+		`]}`
+
+	want := []string{
+		"aaa-data/v2/you@example.com/domain/example.com/cert.pem",
+		"aaa-data/v2/you@example.com/domain/_wildcard.example.com/privkey.pem",
+	}
+
+	sns, err := json.Marshal(map[string]string{"Type": "Notification", "Message": event})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		name string
+		body string
+		want []string
+	}{
+		{name: "S3 event", body: event, want: want},
+		{name: "via SNS", body: string(sns), want: want},
+		{name: "test event", body: `{"Service":"Amazon S3","Event":"s3:TestEvent","Bucket":"b"}`, want: []string{}},
+		{name: "not JSON", body: "hello", want: nil},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := s3EventKeys(tc.body); !slices.Equal(got, tc.want) {
+				t.Errorf("s3EventKeys() = %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
@@ -11,6 +11,7 @@ import (
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/service/lambda"
 	"github.com/aws/aws-sdk-go-v2/service/lambda/types"
+	"github.com/nabeken/aaa/v3/agent"
 	"github.com/nabeken/aaa/v3/command"
 	"github.com/nabeken/aaa/v3/slack"
 )
@@ -55,6 +56,10 @@ func realmain(event json.RawMessage) (*slack.CommandResponse, error) {
 }
 
 func main() {
+	if err := agent.SetupLoggerFromEnv(); err != nil {
+		panic(err)
+	}
+
 	lambdaSvc = lambda.NewFromConfig(command.MustNewAWSConfig(context.Background()))
 
 	golambda.Start(realmain)
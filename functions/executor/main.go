@@ -5,18 +5,15 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
-	"log"
+	"log/slog"
 	"os"
 	"strings"
 
 	golambda "github.com/aws/aws-lambda-go/lambda"
-	"github.com/aws/aws-sdk-go-v2/service/acm"
-	"github.com/aws/aws-sdk-go-v2/service/s3"
 	flags "github.com/jessevdk/go-flags"
 	"github.com/nabeken/aaa/v3/agent"
 	"github.com/nabeken/aaa/v3/command"
 	"github.com/nabeken/aaa/v3/slack"
-	"github.com/nabeken/aws-go-s3/v2/bucket"
 )
 
 var options struct {
@@ -45,7 +42,7 @@ func (d *dispatcher) handleCertCommand(ctx context.Context, arg string, slcmd *s
 		return "", err
 	}
 
-	log.Println("domains:", domains)
+	slog.InfoContext(ctx, "handling cert command", "email", options.Email, "domain", domains[0], "san", domains[1:])
 
 	// How to execute in Slack:
 	// /letsencrypt [command] [domains...] [optional_arguments]
@@ -62,38 +59,69 @@ func (d *dispatcher) handleCertCommand(ctx context.Context, arg string, slcmd *s
 		return "", err
 	}
 
+	if err := (&command.HookRunner{Store: store}).Run(ctx, svc.CommonName); err != nil {
+		return "", fmt.Errorf("the certificate for %s is issued but running the hooks failed: %w", svc.CommonName, err)
+	}
+
+	// the domains have been normalized into A-labels by the service
+	issued := append([]string{svc.CommonName}, svc.Domains...)
+
+	quoted := make([]string, len(issued))
+	for i, dom := range issued {
+		quoted[i] = slack.FormatCode(agent.DisplayDomain(dom))
+	}
+
+	dir := agent.EncodeDomainPath(svc.CommonName)
+
 	return fmt.Sprintf(
 		"%s The certificate for %s is now available!\n```\n"+
 			"aws s3 sync 's3://%s/aaa-data/v2/%s/domain/%s/' '%s'```",
 		slack.FormatUserName(slcmd.UserName),
-		domains,
+		strings.Join(quoted, ", "),
 		options.S3Bucket,
 		options.Email,
-		svc.CommonName,
-		svc.CommonName,
+		dir,
+		dir,
 	), nil
 }
 
 func (d *dispatcher) handleUploadCommand(ctx context.Context, arg string, slcmd *slack.Command) (string, error) {
-	cfg := command.MustNewAWSConfig(ctx)
-	s3b := bucket.New(s3.NewFromConfig(cfg), options.S3Bucket)
-	svc := &command.UploadService{
-		Domain:    arg,
-		Email:     options.Email,
-		S3Filer:   agent.NewS3Filer(s3b, ""),
-		ACMClient: acm.NewFromConfig(cfg),
+	store, err := command.NewStore(options.Email, options.S3Bucket, options.S3KMSKeyID)
+	if err != nil {
+		return "", fmt.Errorf("initializing the store: %w", err)
+	}
+
+	// opts is a subset of command.UploadCommand.
+	var opts struct {
+		NewARN bool `long:"new-arn"`
 	}
 
-	arn, err := svc.Run(ctx)
+	args, err := flags.ParseArgs(&opts, strings.Split(arg, " "))
+	if err != nil {
+		return "", err
+	}
+
+	if len(args) != 1 {
+		return "", errors.New("please specify exactly one domain")
+	}
+
+	domain, err := agent.NormalizeDomain(args[0])
+	if err != nil {
+		return "", err
+	}
+
+	// How to execute in Slack:
+	// /letsencrypt upload [domain] [--new-arn]
+	arns, err := command.UploadToACMTargets(ctx, store, domain, nil, opts.NewARN)
 	if err != nil {
 		return "", err
 	}
 
 	return fmt.Sprintf(
-		"%s The certificate `%s` has been uploaded to ACM! ARN is `%s`",
+		"%s The certificate %s has been uploaded to ACM! ARN is `%s`",
 		slack.FormatUserName(slcmd.UserName),
-		arg,
-		arn,
+		slack.FormatCode(agent.DisplayDomain(domain)),
+		strings.Join(arns, "`, `"),
 	), nil
 }
 
@@ -103,7 +131,7 @@ func realmain(event json.RawMessage) (any, error) {
 		return "", fmt.Errorf("parsing the command: %w", err)
 	}
 
-	log.Println("slack command:", slcmd)
+	slog.Info("received slack command", "command", slcmd.Command, "text", slcmd.Text, "user", slcmd.UserName)
 
 	handleError := func(err error) error {
 		return slack.PostErrorResponse(err, slcmd)
@@ -141,6 +169,10 @@ func realmain(event json.RawMessage) (any, error) {
 }
 
 func main() {
+	if err := agent.SetupLoggerFromEnv(); err != nil {
+		panic(err)
+	}
+
 	// initialize global command option
 	options.S3Bucket = os.Getenv("S3_BUCKET")
 	options.S3KMSKeyID = os.Getenv("KMS_KEY_ID")
@@ -5,8 +5,9 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
-	"log"
+	"log/slog"
 	"os"
+	"strings"
 	"time"
 
 	golambda "github.com/aws/aws-lambda-go/lambda"
@@ -20,10 +21,6 @@ import (
 	"github.com/nabeken/aws-go-s3/v2/bucket"
 )
 
-const (
-	renewalDaysBefore = 30
-)
-
 var (
 	lambdaSvc *lambda.Client
 	s3b       *bucket.Bucket
@@ -51,20 +48,24 @@ func realmain(event json.RawMessage) (any, error) {
 		return nil, fmt.Errorf("listing all the domains: %w", err)
 	}
 
-	now := time.Now()
-	renewalDate := now.AddDate(0, 0, renewalDaysBefore)
+	// the commands keep A-labels for the executor while Slack shows U-labels
 	renewCommands := []string{}
+	renewDisplays := []string{}
+	for _, domain := range command.RenewalTargets(domains, time.Now(), command.DefaultRenewalDaysBefore) {
+		args := append([]string{"cert", domain.Domain}, domain.SANWithoutCommonName()...)
+		renewCommands = append(renewCommands, strings.Join(args, " "))
 
-	for _, domain := range domains {
-		if domain.Certificate.NotAfter.Before(renewalDate) {
-			renewCommands = append(renewCommands, "cert "+domain.Domain)
+		for i := range args[1:] {
+			args[i+1] = agent.DisplayDomain(args[i+1])
 		}
+
+		renewDisplays = append(renewDisplays, strings.Join(args, " "))
 	}
 
-	log.Printf("renewCommands: %s", renewCommands)
+	slog.InfoContext(ctx, "checked renewal", "commands", renewCommands)
 
 	// invoking the executor
-	for _, cmd := range renewCommands {
+	for i, cmd := range renewCommands {
 		slcmd := &slack.Command{
 			Token:       slackToken,
 			UserName:    "here",
@@ -90,7 +91,7 @@ func realmain(event json.RawMessage) (any, error) {
 
 		slackReq := &slack.CommandResponse{
 			ResponseType: "in_channel",
-			Text:         fmt.Sprintf("Invoked `%s` for renewal", cmd),
+			Text:         fmt.Sprintf("Invoked %s for renewal", slack.FormatCode(renewDisplays[i])),
 		}
 
 		if err := slack.PostResponse(slackURL, slackReq); err != nil {
@@ -111,6 +112,10 @@ func realmain(event json.RawMessage) (any, error) {
 }
 
 func main() {
+	if err := agent.SetupLoggerFromEnv(); err != nil {
+		panic(err)
+	}
+
 	cfg := command.MustNewAWSConfig(context.Background())
 
 	lambdaSvc = lambda.NewFromConfig(cfg)
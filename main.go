@@ -16,6 +16,14 @@ func main() {
 }
 
 func realmain() int {
+	parser.CommandHandler = func(cmd flags.Commander, args []string) error {
+		if err := command.SetupLogger(); err != nil {
+			return err
+		}
+
+		return cmd.Execute(args)
+	}
+
 	if _, err := parser.Parse(); err != nil {
 		return 1
 	}
@@ -47,22 +55,72 @@ func init() {
 		"The cert command issues certificates.",
 		&command.CertCommand{},
 	)
+	mustAddCommand(
+		"csr",
+		"Generate a CSR from the stored key",
+		"The csr command generates a CSR signed by the private key stored for the domain.",
+		&command.CSRCommand{},
+	)
 	mustAddCommand(
 		"ls",
 		"List domains",
 		"The ls command lists domains.",
 		&command.LsCommand{},
 	)
+	mustAddCommand(
+		"show",
+		"Show the certificate",
+		"The show command prints the details of the certificate such as the fingerprints, the chain and whether the private key matches.",
+		&command.ShowCommand{},
+	)
+	mustAddCommand(
+		"rm",
+		"Remove the domain",
+		"The rm command removes the certificate, the private key and the configuration of the domain, and drops it from the index so that it is no longer renewed.",
+		&command.RmCommand{},
+	)
+	mustAddCommand(
+		"reindex",
+		"Rebuild the index of the certificates",
+		"The reindex command rebuilds the index of the certificates from scratch for the account, or all the accounts if --email is not given.",
+		&command.ReindexCommand{},
+	)
+
 	mustAddCommand(
 		"sync",
 		"Sync the certificates",
 		"The sync command synchronizes the certificates from S3.",
 		&command.SyncCommand{},
 	)
+	mustAddCommand(
+		"export",
+		"Export the certificate",
+		"The export command writes the certificate and the private key in PEM, DER, PKCS#12 or JKS.",
+		&command.ExportCommand{},
+	)
+
 	mustAddCommand(
 		"upload",
-		"Upload the certificate to IAM",
-		"The upload command uploads the certificates to IAM.",
+		"Upload the certificate to AWS",
+		"The upload command uploads the certificates to ACM, IAM, API Gateway or ALB.",
 		&command.UploadCommand{},
 	)
+	mustAddCommand(
+		"config",
+		"Configure the domain",
+		"The config command shows or saves the configuration for the domain such as the hooks.",
+		&command.ConfigCommand{},
+	)
+	mustAddCommand(
+		"daemon",
+		"Run the renewal daemon",
+		"The daemon command checks the renewal on the interval and renews the certificates in-process.",
+		&command.DaemonCommand{},
+	)
+	mustAddCommand(
+		"exporter",
+		"Export metrics of the certificates",
+		"The exporter command exposes the expiry and the issuance metrics of the certificates for Prometheus.",
+		&command.ExporterCommand{},
+	)
 }
@@ -7,6 +7,7 @@ import (
 	"io"
 	"net/http"
 	"net/url"
+	"strings"
 )
 
 type Command struct {
@@ -77,12 +78,17 @@ func PostResponse(respURL string, cmdResp *CommandResponse) error {
 func PostErrorResponse(err error, slcmd *Command) error {
 	resp := &CommandResponse{
 		ResponseType: "in_channel",
-		Text:         fmt.Sprintf("%s ERROR: `%s`", FormatUserName(slcmd.UserName), err),
+		Text:         fmt.Sprintf("%s ERROR: %s", FormatUserName(slcmd.UserName), FormatCode(err.Error())),
 	}
 
 	return PostResponse(slcmd.ResponseURL, resp)
 }
 
+// FormatCode quotes s as the inline code so that `*` in the wildcard domains is not taken as the bold text.
+func FormatCode(s string) string {
+	return "`" + strings.ReplaceAll(s, "`", "'") + "`"
+}
+
 // https://api.slack.com/docs/message-formatting#linking_to_channels_and_users
 func FormatUserName(name string) string {
 	switch name {